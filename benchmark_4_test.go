@@ -1,6 +1,10 @@
 package main
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/yugant007/advanced-golang-concurrency/stages"
+)
 
 func BenchmarkGeneric(b *testing.B) {
 	done := make(chan interface{})
@@ -112,3 +116,23 @@ func BenchmarkTyped(b *testing.B) {
 	for range take(done, repeat(done, "a"), b.N) {
 	}
 }
+
+func BenchmarkGenericStages(b *testing.B) { //The same pipeline as BenchmarkTyped, with the stages written once using type parameters.
+	done := make(chan interface{})
+	defer close(done)
+
+	b.ResetTimer()
+	for range stages.Take(done, stages.Repeat(done, "a"), b.N) {
+	}
+}
+
+func BenchmarkGenericStagesMap(b *testing.B) { //And the same pipeline as BenchmarkGeneric, whose toString becomes a Map that needs no type assertion.
+	done := make(chan interface{})
+	defer close(done)
+
+	toString := func(v string) string { return v }
+
+	b.ResetTimer()
+	for range stages.Map(done, stages.Take(done, stages.Repeat(done, "a"), b.N), toString) {
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"testing"
+)
+
+// run -> go test -bench=WrapError -benchmem benchmark_5_test.go example-4.go
+
+var errBench = errors.New("disk full")
+
+func BenchmarkWrapErrorDebugStack(b *testing.B) { // This is how wrapError used to capture the stack: format the whole thing on every wrap.
+	for i := 0; i < b.N; i++ {
+		_ = struct {
+			MyError
+			StackTrace string
+		}{
+			MyError: MyError{
+				Inner:   errBench,
+				Message: fmt.Sprintf("cannot write %q", "tally"),
+				Misc:    make(map[string]interface{}),
+			},
+			StackTrace: string(debug.Stack()),
+		}
+	}
+}
+
+func BenchmarkWrapError(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		_ = wrapError(errBench, "cannot write %q", "tally")
+	}
+}
+
+func BenchmarkWrapErrorPrinted(b *testing.B) { // The cost we now only pay when someone actually looks at the stack.
+	for i := 0; i < b.N; i++ {
+		_ = wrapError(errBench, "cannot write %q", "tally").StackTrace.String()
+	}
+}
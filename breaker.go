@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// run -> go test breaker_test.go breaker.go example-4.go
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("breakerState(%d)", int(s))
+}
+
+// callOutcome is what a call's error tells the breaker about whatever is behind it.
+type callOutcome int
+
+const (
+	callSucceeded callOutcome = iota
+	callFailed
+	callIgnored // Nothing either way, e.g. the caller gave up. The call only hands back its probe slot.
+)
+
+type breakerConfig struct {
+	Name                string
+	ConsecutiveFailures int           // Trip after this many failures in a row. Zero disables this check.
+	FailureRate         float64       // Trip when this fraction of the calls in the current window failed. Zero disables this check.
+	MinRequests         int           // The failure rate only counts once the window has seen this many calls.
+	Window              time.Duration // How long the failure rate is measured over before the counts reset.
+	OpenTimeout         time.Duration // How long we fail fast before letting probe calls through.
+	HalfOpenProbes      int           // How many probes may run at once, and how many must succeed to close again.
+	Classify            func(error) callOutcome
+	OnStateChange       func(name string, from, to breakerState)
+}
+
+func newBreaker(config breakerConfig) *breaker {
+	if config.Window <= 0 {
+		config.Window = 10 * time.Second
+	}
+	if config.OpenTimeout <= 0 {
+		config.OpenTimeout = 5 * time.Second
+	}
+	if config.HalfOpenProbes <= 0 {
+		config.HalfOpenProbes = 1
+	}
+	if config.Classify == nil {
+		config.Classify = func(err error) callOutcome {
+			switch {
+			case err == nil:
+				return callSucceeded
+			case errors.Is(err, context.Canceled): //A caller giving up says nothing about the health of whatever is behind the breaker.
+				return callIgnored
+			}
+			return callFailed
+		}
+	}
+	b := &breaker{config: config, now: time.Now}
+	b.windowStart = b.now()
+	return b
+}
+
+type breaker struct {
+	config breakerConfig
+	now    func() time.Time
+
+	mu                  sync.Mutex
+	state               breakerState
+	generation          int // Bumped on every state change, so a call's result only counts in the state that let it through.
+	openedAt            time.Time
+	windowStart         time.Time
+	requests, failures  int
+	consecutiveFailures int
+	probes, probeWins   int
+}
+
+func (b *breaker) State() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *breaker) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	generation, err := b.allow()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			b.record(generation, callFailed) //Here we hand back the probe slot even when fn panics, or a half-open breaker would turn everyone away forever.
+			panic(r)
+		}
+	}()
+	err = fn(ctx)
+	b.record(generation, b.config.Classify(err))
+	return err
+}
+
+func (b *breaker) allow() (int, error) {
+	b.mu.Lock()
+	var notify func()
+	defer func() {
+		b.mu.Unlock()
+		if notify != nil {
+			notify() //Callbacks run outside the lock so they're free to call back into the breaker.
+		}
+	}()
+
+	switch b.state {
+	case breakerOpen:
+		if wait := b.config.OpenTimeout - b.now().Sub(b.openedAt); wait > 0 {
+			return 0, b.openErr(wait)
+		}
+		notify = b.setState(breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probes >= b.config.HalfOpenProbes { //Here we only let a few probes through; everyone else keeps failing fast until we know whether the backend is back.
+			return 0, b.openErr(0)
+		}
+		b.probes++
+	}
+	return b.generation, nil
+}
+
+func (b *breaker) record(generation int, outcome callOutcome) {
+	b.mu.Lock()
+	var notify func()
+	defer func() {
+		b.mu.Unlock()
+		if notify != nil {
+			notify()
+		}
+	}()
+
+	if generation != b.generation {
+		return //Here we drop the result of a call let through before the last state change, e.g. a slow call that started before we tripped; it is not one of our probes.
+	}
+	if b.state == breakerHalfOpen {
+		b.probes--
+		switch outcome {
+		case callFailed:
+			notify = b.setState(breakerOpen)
+		case callSucceeded:
+			if b.probeWins++; b.probeWins >= b.config.HalfOpenProbes {
+				notify = b.setState(breakerClosed)
+			}
+		}
+		return
+	}
+	if outcome == callIgnored {
+		return
+	}
+
+	if now := b.now(); now.Sub(b.windowStart) >= b.config.Window {
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+	b.requests++
+	if outcome == callSucceeded {
+		b.consecutiveFailures = 0
+		return
+	}
+	b.failures++
+	b.consecutiveFailures++
+
+	tooManyInARow := b.config.ConsecutiveFailures > 0 && b.consecutiveFailures >= b.config.ConsecutiveFailures
+	tooHighARate := b.config.FailureRate > 0 && b.requests >= b.config.MinRequests &&
+		float64(b.failures)/float64(b.requests) >= b.config.FailureRate
+	if tooManyInARow || tooHighARate {
+		notify = b.setState(breakerOpen)
+	}
+}
+
+func (b *breaker) setState(to breakerState) func() {
+	from := b.state
+	if from == to {
+		return nil
+	}
+	b.state = to
+	b.generation++
+	b.probes, b.probeWins = 0, 0
+	switch to {
+	case breakerOpen:
+		b.openedAt = b.now()
+	case breakerClosed:
+		b.windowStart, b.requests, b.failures, b.consecutiveFailures = b.now(), 0, 0, 0
+	}
+	if b.config.OnStateChange == nil {
+		return nil
+	}
+	return func() { b.config.OnStateChange(b.config.Name, from, to) }
+}
+
+func (b *breaker) openErr(retryAfter time.Duration) error {
+	err := wrapError(errCircuitOpen, "%s is unavailable; please try again shortly", b.config.Name) //To the user this is a well-formed, transient error: the breaker is doing its job, not failing at it.
+	err.Misc["breaker"] = b.config.Name
+	err.Misc["retryAfter"] = retryAfter.String()
+	err.Misc["transient"] = true
+	return IntermediateErr{err}
+}
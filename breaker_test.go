@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+var errBackendDown = errors.New("backend down")
+
+func failing(ctx context.Context) error    { return errBackendDown }
+func succeeding(ctx context.Context) error { return nil }
+
+func TestBreaker_TripsOnConsecutiveFailures(t *testing.T) {
+	var transitions []string
+	b := newBreaker(breakerConfig{
+		Name:                "api",
+		ConsecutiveFailures: 3,
+		OnStateChange: func(name string, from, to breakerState) {
+			transitions = append(transitions, name+": "+from.String()+" -> "+to.String())
+		},
+	})
+
+	b.Do(context.Background(), failing)
+	b.Do(context.Background(), failing)
+	b.Do(context.Background(), succeeding) //A success in between resets the run.
+	b.Do(context.Background(), failing)
+	b.Do(context.Background(), failing)
+	if b.State() != breakerClosed {
+		t.Fatalf("expected breaker to stay closed, got %v", b.State())
+	}
+	b.Do(context.Background(), failing)
+	if b.State() != breakerOpen {
+		t.Fatalf("expected breaker to open, got %v", b.State())
+	}
+
+	called := false
+	err := b.Do(context.Background(), func(ctx context.Context) error { called = true; return nil })
+	if called {
+		t.Errorf("expected open breaker not to call through")
+	}
+	if !errors.Is(err, errCircuitOpen) || classifyError(err) != errClassWellFormed || !isTransient(err) {
+		t.Errorf("expected a well-formed, transient circuit-open error, got %#v", err)
+	}
+	if expected := []string{"api: closed -> open"}; !reflect.DeepEqual(transitions, expected) {
+		t.Errorf("expected transitions %v, but received %v", expected, transitions)
+	}
+}
+
+func TestBreaker_TripsOnFailureRate(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	b := newBreaker(breakerConfig{FailureRate: 0.5, MinRequests: 4, Window: time.Minute})
+	b.now = clock.Now
+	b.windowStart = clock.Now()
+
+	b.Do(context.Background(), failing)
+	b.Do(context.Background(), failing)
+	b.Do(context.Background(), failing)
+	if b.State() != breakerClosed {
+		t.Fatalf("expected breaker to wait for MinRequests, got %v", b.State())
+	}
+
+	clock.Advance(2 * time.Minute) //A new window forgets the old failures.
+	for i := 0; i < 3; i++ {
+		b.Do(context.Background(), succeeding)
+	}
+	b.Do(context.Background(), failing)
+	if b.State() != breakerClosed {
+		t.Fatalf("expected 1 in 4 failures to keep breaker closed, got %v", b.State())
+	}
+	for i := 0; i < 2; i++ {
+		b.Do(context.Background(), failing)
+	}
+	if b.State() != breakerOpen {
+		t.Fatalf("expected 3 in 6 failures to open breaker, got %v", b.State())
+	}
+}
+
+func TestBreaker_HalfOpenProbes(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	var transitions []breakerState
+	b := newBreaker(breakerConfig{
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Second,
+		HalfOpenProbes:      2,
+		OnStateChange: func(name string, from, to breakerState) {
+			transitions = append(transitions, to)
+		},
+	})
+	b.now = clock.Now
+
+	b.Do(context.Background(), failing)
+	clock.Advance(time.Second)
+
+	b.Do(context.Background(), failing) //The first probe fails, so we go straight back to open.
+	if b.State() != breakerOpen {
+		t.Fatalf("expected a failed probe to reopen the breaker, got %v", b.State())
+	}
+
+	clock.Advance(time.Second)
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Do(context.Background(), func(ctx context.Context) error { <-release; return nil })
+		}()
+	}
+	for b.State() != breakerHalfOpen {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if err := b.Do(context.Background(), succeeding); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("expected a third concurrent probe to be turned away, got %v", err)
+	}
+	close(release)
+	wg.Wait()
+
+	if b.State() != breakerClosed {
+		t.Fatalf("expected two successful probes to close the breaker, got %v", b.State())
+	}
+	expected := []breakerState{breakerOpen, breakerHalfOpen, breakerOpen, breakerHalfOpen, breakerClosed}
+	if !reflect.DeepEqual(transitions, expected) {
+		t.Errorf("expected transitions %v, but received %v", expected, transitions)
+	}
+}
+
+func TestBreaker_IgnoresCallerCancellation(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	b := newBreaker(breakerConfig{ConsecutiveFailures: 2, OpenTimeout: time.Second})
+	b.now = clock.Now
+	canceled := func(ctx context.Context) error { return context.Canceled }
+
+	b.Do(context.Background(), canceled)
+	b.Do(context.Background(), canceled)
+	if b.State() != breakerClosed {
+		t.Fatalf("expected cancellation not to count as a failure")
+	}
+	b.Do(context.Background(), failing)
+	b.Do(context.Background(), canceled) //Nor as a success, which would reset the run of failures.
+	b.Do(context.Background(), failing)
+	if b.State() != breakerOpen {
+		t.Fatalf("expected two failures with a cancellation between them to open the breaker, got %v", b.State())
+	}
+
+	clock.Advance(time.Second)
+	b.Do(context.Background(), canceled) //A probe whose caller gave up tells us nothing, but frees its slot for the next one.
+	if b.State() != breakerHalfOpen {
+		t.Fatalf("expected a canceled probe to leave the breaker half-open, got %v", b.State())
+	}
+	b.Do(context.Background(), succeeding)
+	if b.State() != breakerClosed {
+		t.Fatalf("expected the next probe to close the breaker, got %v", b.State())
+	}
+}
+
+func TestBreaker_IgnoresCallsFromBeforeTheTrip(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	b := newBreaker(breakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Second})
+	b.now = clock.Now
+
+	slowStarted, slowRelease, slowDone := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(slowDone)
+		b.Do(context.Background(), func(ctx context.Context) error { close(slowStarted); <-slowRelease; return nil })
+	}()
+	<-slowStarted
+	b.Do(context.Background(), failing)
+	clock.Advance(time.Second)
+
+	probeStarted, probeRelease, probeDone := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(probeDone)
+		b.Do(context.Background(), func(ctx context.Context) error { close(probeStarted); <-probeRelease; return errBackendDown })
+	}()
+	<-probeStarted
+	close(slowRelease) //The slow call was let through while closed, so its success is not the probe's.
+	<-slowDone
+	if b.State() != breakerHalfOpen {
+		t.Fatalf("expected a call from before the trip not to close the breaker, got %v", b.State())
+	}
+	if err := b.Do(context.Background(), succeeding); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("expected the probe's slot to still be taken, got %v", err)
+	}
+	close(probeRelease)
+	<-probeDone
+	if b.State() != breakerOpen {
+		t.Fatalf("expected the real probe's failure to reopen the breaker, got %v", b.State())
+	}
+}
+
+func TestBreaker_WrapsAPIConnection(t *testing.T) {
+	conn := Open3()
+	b := newBreaker(breakerConfig{Name: "disk", ConsecutiveFailures: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Do(ctx, conn.ReadFile3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := b.Do(ctx, conn.ReadFile3); err == nil { //The disk limiter allows one read a second, so this one can't make our deadline.
+		t.Fatalf("expected the second read to fail on the limiter")
+	}
+	if err := b.Do(ctx, conn.ReadFile3); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("expected the breaker to fail fast, got %v", err)
+	}
+}
+
+func TestBreaker_PanickingProbeFails(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	b := newBreaker(breakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Second})
+	b.now = clock.Now
+
+	b.Do(context.Background(), failing)
+	clock.Advance(time.Second)
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("expected the probe's panic to reach us, got %v", r)
+			}
+		}()
+		b.Do(context.Background(), func(ctx context.Context) error { panic("boom") })
+	}()
+	if b.State() != breakerOpen {
+		t.Fatalf("expected a panicking probe to count as a failure, got %v", b.State())
+	}
+
+	clock.Advance(time.Second)
+	if err := b.Do(context.Background(), succeeding); err != nil || b.State() != breakerClosed {
+		t.Fatalf("expected the next probe to get through and close the breaker, got %v and %v", err, b.State())
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// run -> go test bulkhead_test.go bulkhead.go example-4.go
+
+var (
+	errBulkheadFull    = errors.New("bulkhead queue is full")
+	errBulkheadTimeout = errors.New("timed out waiting in bulkhead queue")
+)
+
+func newBulkhead(name string, concurrency, queueSize int, queueTimeout time.Duration) *bulkhead {
+	return &bulkhead{
+		name:         name,
+		slots:        make(chan struct{}, concurrency),
+		queue:        make(chan struct{}, queueSize),
+		queueTimeout: queueTimeout,
+	}
+}
+
+type bulkhead struct {
+	name         string
+	slots        chan struct{} //Here we bound how many callers may be doing this class of work at once.
+	queue        chan struct{} //And here how many may wait for a turn. Anyone beyond that is turned away immediately.
+	queueTimeout time.Duration
+
+	mu    sync.Mutex
+	stats bulkheadStats
+}
+
+type bulkheadStats struct {
+	Active, Queued         int // Right now.
+	PeakActive, PeakQueued int
+	Completed              int64
+	Rejected               int64 // Turned away because the queue was full.
+	TimedOut               int64 // Gave up waiting in the queue, either on queueTimeout or on their own context.
+	QueueWait              time.Duration
+}
+
+func (b *bulkhead) Stats() bulkheadStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
+
+func (b *bulkhead) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	select {
+	case b.slots <- struct{}{}:
+		b.update(func(s *bulkheadStats) { s.Active++ })
+	default:
+		if err := b.wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	defer func() {
+		<-b.slots
+		b.update(func(s *bulkheadStats) { s.Active--; s.Completed++ })
+	}()
+	return fn(ctx)
+}
+
+func (b *bulkhead) wait(ctx context.Context) error {
+	select {
+	case b.queue <- struct{}{}:
+	default:
+		b.update(func(s *bulkheadStats) { s.Rejected++ })
+		return b.rejection(errBulkheadFull)
+	}
+	defer func() { <-b.queue }()
+	b.update(func(s *bulkheadStats) { s.Queued++ })
+
+	start := time.Now()
+	timeout := time.NewTimer(b.queueTimeout)
+	defer timeout.Stop()
+
+	var err error
+	select {
+	case b.slots <- struct{}{}:
+	case <-timeout.C:
+		err = b.rejection(errBulkheadTimeout)
+	case <-ctx.Done():
+		err = b.rejection(ctx.Err())
+	}
+
+	waited := time.Since(start)
+	b.update(func(s *bulkheadStats) {
+		s.Queued--
+		s.QueueWait += waited
+		if err != nil {
+			s.TimedOut++
+		} else {
+			s.Active++
+		}
+	})
+	return err
+}
+
+func (b *bulkhead) update(change func(s *bulkheadStats)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	change(&b.stats)
+	if b.stats.Active > b.stats.PeakActive {
+		b.stats.PeakActive = b.stats.Active
+	}
+	if b.stats.Queued > b.stats.PeakQueued {
+		b.stats.PeakQueued = b.stats.Queued
+	}
+}
+
+func (b *bulkhead) rejection(reason error) error {
+	err := wrapError(reason, "too many %s requests in flight; please try again shortly", b.name)
+	err.Misc["bulkhead"] = b.name
+	err.Misc["transient"] = reason != context.Canceled //Our own caller giving up isn't something to retry; a busy bulkhead is.
+	return IntermediateErr{err}
+}
+
+func Open4() *APIConnection4 {
+	return &APIConnection4{
+		APIConnection3:  Open3(),
+		diskBulkhead:    newBulkhead("disk", 4, 16, time.Second), //Here we give each resource class its own pool and queue, so a stalled disk can only ever tie up the disk callers.
+		networkBulkhead: newBulkhead("network", 8, 32, time.Second),
+	}
+}
+
+type APIConnection4 struct {
+	*APIConnection3
+	diskBulkhead,
+	networkBulkhead *bulkhead
+}
+
+func (a *APIConnection4) ReadFile4(ctx context.Context) error {
+	return a.diskBulkhead.Do(ctx, a.ReadFile3)
+}
+
+func (a *APIConnection4) ResolveAddress4(ctx context.Context) error {
+	return a.networkBulkhead.Do(ctx, a.ResolveAddress3)
+}
+
+func (a *APIConnection4) Stats() map[string]bulkheadStats {
+	return map[string]bulkheadStats{
+		a.diskBulkhead.name:    a.diskBulkhead.Stats(),
+		a.networkBulkhead.name: a.networkBulkhead.Stats(),
+	}
+}
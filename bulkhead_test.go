@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func stall(release <-chan struct{}) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil
+	}
+}
+
+func waitForStats(t *testing.T, b *bulkhead, ready func(bulkheadStats) bool) {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for !ready(b.Stats()) {
+		select {
+		case <-deadline:
+			t.Fatalf("bulkhead never reached expected state: %+v", b.Stats())
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestBulkhead_QueuesThenRejects(t *testing.T) {
+	b := newBulkhead("disk", 2, 1, time.Second)
+	release := make(chan struct{})
+
+	for i := 0; i < 3; i++ {
+		go b.Do(context.Background(), stall(release))
+	}
+	waitForStats(t, b, func(s bulkheadStats) bool { return s.Active == 2 && s.Queued == 1 })
+
+	err := b.Do(context.Background(), stall(release))
+	if !errors.Is(err, errBulkheadFull) || classifyError(err) != errClassWellFormed || !isTransient(err) {
+		t.Errorf("expected a well-formed, transient bulkhead-full error, got %#v", err)
+	}
+
+	close(release)
+	waitForStats(t, b, func(s bulkheadStats) bool { return s.Completed == 3 })
+
+	stats := b.Stats()
+	if stats.Active != 0 || stats.Queued != 0 || stats.PeakActive != 2 || stats.PeakQueued != 1 || stats.Rejected != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBulkhead_QueueTimeout(t *testing.T) {
+	b := newBulkhead("disk", 1, 1, 20*time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+
+	go b.Do(context.Background(), stall(release))
+	waitForStats(t, b, func(s bulkheadStats) bool { return s.Active == 1 })
+
+	err := b.Do(context.Background(), stall(release))
+	if !errors.Is(err, errBulkheadTimeout) {
+		t.Errorf("expected a queue timeout, got %v", err)
+	}
+	if stats := b.Stats(); stats.TimedOut != 1 || stats.QueueWait < 20*time.Millisecond {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestBulkhead_CallerContextWhileQueued(t *testing.T) {
+	b := newBulkhead("disk", 1, 1, time.Second)
+	release := make(chan struct{})
+	defer close(release)
+
+	go b.Do(context.Background(), stall(release))
+	waitForStats(t, b, func(s bulkheadStats) bool { return s.Active == 1 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.Do(ctx, stall(release)); !errors.Is(err, context.Canceled) || isTransient(err) {
+		t.Errorf("expected a non-transient cancellation, got %#v", err)
+	}
+}
+
+func TestAPIConnection4_StalledDiskDoesNotBlockNetwork(t *testing.T) {
+	conn := Open4()
+	release := make(chan struct{})
+	defer close(release)
+
+	for i := 0; i < 20; i++ { //Fill every disk slot and the whole disk queue with reads that never finish, then some.
+		go conn.diskBulkhead.Do(context.Background(), stall(release))
+	}
+	waitForStats(t, conn.diskBulkhead, func(s bulkheadStats) bool { return s.Active == 4 && s.Queued == 16 })
+
+	if err := conn.ReadFile4(context.Background()); !errors.Is(err, errBulkheadFull) {
+		t.Errorf("expected disk reads to be turned away, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := conn.ResolveAddress4(ctx); err != nil {
+		t.Errorf("expected network callers to be unaffected, got %v", err)
+	}
+
+	stats := conn.Stats()
+	if stats["disk"].Rejected < 1 || stats["network"].Completed != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,119 @@
+package ctxutil
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Step is one named piece of work sharing a Budget. Weight decides its share of whatever time is left when
+// it starts; Min is the least it can do anything useful with.
+type Step struct {
+	Name   string
+	Weight float64
+	Min    time.Duration
+}
+
+// BudgetError is returned when a step's share of the deadline is already below its Min. It unwraps to
+// context.DeadlineExceeded, the same error locale3 fails fast with.
+type BudgetError struct {
+	Step       string
+	Share, Min time.Duration
+}
+
+func (err *BudgetError) Error() string {
+	return fmt.Sprintf("step %q needs at least %v but only %v is left for it", err.Step, err.Min, err.Share)
+}
+
+func (err *BudgetError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// Budget splits whatever is left of a context's deadline across a sequence of steps. Shares are worked out
+// when each step starts, so time a fast step didn't use flows on to the steps after it.
+type Budget struct {
+	parent  context.Context
+	reserve time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	steps   []Step
+	started map[string]bool
+}
+
+// NewBudget holds back reserve from the steps so there's still time to clean up under parent once they're done.
+func NewBudget(parent context.Context, reserve time.Duration, steps ...Step) *Budget {
+	return &Budget{
+		parent:  parent,
+		reserve: reserve,
+		now:     time.Now,
+		steps:   steps,
+		started: make(map[string]bool, len(steps)),
+	}
+}
+
+// Start returns the context the named step should run under. If the step's share is below its Min, Start
+// fails fast with a *BudgetError instead of letting the step run out of time halfway through.
+func (b *Budget) Start(name string) (context.Context, context.CancelFunc, error) {
+	share, err := b.allocate(name)
+	if err != nil {
+		return b.parent, func() {}, err
+	}
+	if share < 0 { //No deadline upstream, so there is nothing to split.
+		ctx, cancel := context.WithCancel(b.parent)
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithDeadline(b.parent, b.now().Add(share))
+	return ctx, cancel, nil
+}
+
+func (b *Budget) allocate(name string) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	index := -1
+	for i, step := range b.steps {
+		if step.Name == name {
+			index = i
+		}
+	}
+	if index < 0 {
+		return 0, fmt.Errorf("ctxutil: no step %q in budget", name)
+	}
+	if b.started[name] {
+		return 0, fmt.Errorf("ctxutil: step %q already started", name)
+	}
+	b.started[name] = true
+	step := b.steps[index]
+
+	deadline, ok := b.parent.Deadline()
+	if !ok {
+		return -1, nil
+	}
+	remaining := deadline.Sub(b.now()) - b.reserve
+
+	weights := step.Weight
+	var laterMins time.Duration
+	for _, later := range b.steps {
+		if later.Name != name && !b.started[later.Name] {
+			weights += later.Weight
+			laterMins += later.Min
+		}
+	}
+
+	share := remaining
+	if weights > 0 {
+		share = time.Duration(float64(remaining) * step.Weight / weights)
+	}
+	if share < step.Min {
+		share = step.Min //Here we let a step take more than its weighted share to reach its minimum...
+	}
+	if ceiling := remaining - laterMins; share > ceiling {
+		share = ceiling //...but never so much that a step still to come can't have its own.
+	}
+	if share < step.Min || share <= 0 {
+		return 0, &BudgetError{Step: name, Share: share, Min: step.Min}
+	}
+	return share, nil
+}
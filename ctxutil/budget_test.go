@@ -0,0 +1,104 @@
+package ctxutil
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestBudget(t *testing.T, timeout, reserve time.Duration, steps ...Step) (*Budget, *time.Time) {
+	t.Helper()
+	now := time.Now()
+	ctx, cancel := context.WithDeadline(context.Background(), now.Add(timeout))
+	t.Cleanup(cancel)
+	b := NewBudget(ctx, reserve, steps...)
+	b.now = func() time.Time { return now }
+	return b, &now
+}
+
+func remaining(ctx context.Context, now time.Time) time.Duration {
+	deadline, _ := ctx.Deadline()
+	return deadline.Sub(now)
+}
+
+func TestBudget_SplitsByWeight(t *testing.T) {
+	b, now := newTestBudget(t, 10*time.Second, 2*time.Second,
+		Step{Name: "locale", Weight: 3},
+		Step{Name: "render", Weight: 1},
+	)
+
+	ctx, cancel, err := b.Start("locale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	if got := remaining(ctx, *now); got != 6*time.Second {
+		t.Errorf("expected locale to get 3/4 of 8s, got %v", got)
+	}
+
+	*now = now.Add(time.Second) //locale finished early, so render inherits what it didn't use.
+	ctx, cancel, err = b.Start("render")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	if got := remaining(ctx, *now); got != 7*time.Second {
+		t.Errorf("expected render to get all of the remaining 7s, got %v", got)
+	}
+}
+
+func TestBudget_ProtectsLaterMinimums(t *testing.T) {
+	b, now := newTestBudget(t, 10*time.Second, 0,
+		Step{Name: "fetch", Weight: 9},
+		Step{Name: "store", Weight: 1, Min: 4 * time.Second},
+	)
+
+	ctx, cancel, err := b.Start("fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	if got := remaining(ctx, *now); got != 6*time.Second {
+		t.Errorf("expected fetch to leave store its 4s minimum, got %v", got)
+	}
+}
+
+func TestBudget_FailsFastBelowMinimum(t *testing.T) {
+	b, _ := newTestBudget(t, 30*time.Second, time.Second,
+		Step{Name: "locale", Weight: 1, Min: time.Minute},
+	)
+
+	_, cancel, err := b.Start("locale")
+	defer cancel()
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) || budgetErr.Step != "locale" || budgetErr.Share != 29*time.Second {
+		t.Fatalf("expected a BudgetError for locale, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected BudgetError to unwrap to context.DeadlineExceeded")
+	}
+}
+
+func TestBudget_NoDeadline(t *testing.T) {
+	b := NewBudget(context.Background(), time.Second, Step{Name: "locale", Min: time.Minute})
+	ctx, cancel, err := b.Start("locale")
+	defer cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ctx.Deadline(); ok {
+		t.Errorf("expected no deadline without one upstream")
+	}
+}
+
+func TestBudget_UnknownAndRepeatedSteps(t *testing.T) {
+	b := NewBudget(context.Background(), 0, Step{Name: "locale"})
+	if _, _, err := b.Start("render"); err == nil {
+		t.Errorf("expected an error for an unknown step")
+	}
+	b.Start("locale")
+	if _, _, err := b.Start("locale"); err == nil {
+		t.Errorf("expected an error for a step started twice")
+	}
+}
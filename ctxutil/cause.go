@@ -0,0 +1,196 @@
+package ctxutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cause records why a context was canceled: the error, which context it was, and when and where whoever
+// canceled it did so. For timeouts there is no canceler, so Time and the stack are where the timeout was set.
+type Cause struct {
+	Err  error
+	Name string
+	Time time.Time
+	pcs  []uintptr
+}
+
+func (c *Cause) Error() string {
+	return fmt.Sprintf("%s: %v", c.Name, c.Err)
+}
+
+func (c *Cause) Unwrap() error {
+	return c.Err
+}
+
+func (c *Cause) Stack() string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(c.pcs)
+	for {
+		frame, more := frames.Next()
+		if frame.Function != "" {
+			fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		}
+		if !more {
+			return b.String()
+		}
+	}
+}
+
+func newCause(name string, err error, skip int) *Cause {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(skip+2, pcs)
+	return &Cause{Err: err, Name: name, Time: time.Now(), pcs: pcs[:n]}
+}
+
+// CauseOf returns the *Cause that ended ctx, or nil if ctx is still live or was ended by something that
+// didn't record one.
+func CauseOf(ctx context.Context) *Cause {
+	var cause *Cause
+	if errors.As(context.Cause(ctx), &cause) {
+		return cause
+	}
+	return nil
+}
+
+// WithCancel is context.WithCancelCause that also remembers who called cancel. A nil err means
+// context.Canceled.
+func WithCancel(parent context.Context, name string) (context.Context, context.CancelCauseFunc) {
+	return (*Registry)(nil).WithCancel(parent, name)
+}
+
+// WithTimeout is context.WithTimeout whose cause, when the time runs out, names the context and points at
+// where the timeout was set.
+func WithTimeout(parent context.Context, name string, timeout time.Duration) (context.Context, context.CancelCauseFunc) {
+	return (*Registry)(nil).withTimeout(parent, name, timeout)
+}
+
+// Registry keeps track of the contexts derived through it, so the live tree can be dumped when we want to
+// know what is still running, what it is waiting on, and what canceled it.
+type Registry struct {
+	mu    sync.Mutex
+	nodes map[*node]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{nodes: make(map[*node]bool)}
+}
+
+type node struct {
+	name     string
+	parent   *node
+	ctx      context.Context
+	released bool
+}
+
+type nodeKey struct{ r *Registry }
+
+func (r *Registry) WithCancel(parent context.Context, name string) (context.Context, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	return r.track(parent, ctx, name, func(cause *Cause) { cancel(cause) })
+}
+
+func (r *Registry) WithTimeout(parent context.Context, name string, timeout time.Duration) (context.Context, context.CancelCauseFunc) {
+	return r.withTimeout(parent, name, timeout)
+}
+
+func (r *Registry) withTimeout(parent context.Context, name string, timeout time.Duration) (context.Context, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	ctx, stop := context.WithTimeoutCause(ctx, timeout, newCause(name, context.DeadlineExceeded, 2)) //Nobody calls cancel when time runs out, so the most useful stack we have is whoever set the timeout.
+	return r.track(parent, ctx, name, func(cause *Cause) {
+		cancel(cause)
+		stop()
+	})
+}
+
+func (r *Registry) track(parent, ctx context.Context, name string, cancel func(*Cause)) (context.Context, context.CancelCauseFunc) {
+	var n *node
+	if r != nil {
+		n = &node{name: name}
+		n.parent, _ = parent.Value(nodeKey{r}).(*node)
+		ctx = context.WithValue(ctx, nodeKey{r}, n)
+		n.ctx = ctx
+
+		r.mu.Lock()
+		r.nodes[n] = true
+		r.mu.Unlock()
+	}
+
+	var once sync.Once
+	return ctx, func(err error) {
+		if err == nil {
+			err = context.Canceled
+		}
+		cancel(newCause(name, err, 1)) //Here we capture the stack of whoever called cancel, which is the question we couldn't answer before.
+		if n == nil {
+			return
+		}
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			n.released = true
+			r.prune()
+		})
+	}
+}
+
+func (r *Registry) prune() { //A node leaves the tree once its CancelFunc has been called and nothing under it is still around.
+	for {
+		live := make(map[*node]bool)
+		for n := range r.nodes {
+			if n.parent != nil {
+				live[n.parent] = true
+			}
+		}
+		removed := false
+		for n := range r.nodes {
+			if n.released && !live[n] {
+				delete(r.nodes, n)
+				removed = true
+			}
+		}
+		if !removed {
+			return
+		}
+	}
+}
+
+// Dump writes the tree of contexts that are still held, one per line, with the time left before each
+// deadline and, for contexts that have ended, their cause.
+func (r *Registry) Dump(w io.Writer) {
+	r.mu.Lock()
+	children := make(map[*node][]*node)
+	for n := range r.nodes {
+		parent := n.parent
+		if !r.nodes[parent] {
+			parent = nil //The parent was never tracked or has already left the tree, so its children are shown as roots.
+		}
+		children[parent] = append(children[parent], n)
+	}
+	r.mu.Unlock()
+
+	now := time.Now()
+	var dump func(parent *node, depth int)
+	dump = func(parent *node, depth int) {
+		kids := children[parent]
+		sort.Slice(kids, func(i, j int) bool { return kids[i].name < kids[j].name })
+		for _, n := range kids {
+			line := strings.Repeat("  ", depth) + n.name
+			if deadline, ok := n.ctx.Deadline(); ok {
+				line += fmt.Sprintf(" [deadline in %v]", deadline.Sub(now).Round(time.Millisecond))
+			}
+			if n.ctx.Err() != nil {
+				line += fmt.Sprintf(" done: %v", context.Cause(n.ctx))
+			}
+			fmt.Fprintln(w, line)
+			dump(n, depth+1)
+		}
+	}
+	dump(nil, 0)
+}
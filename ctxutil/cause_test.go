@@ -0,0 +1,100 @@
+package ctxutil
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errSibling = errors.New("sibling failed")
+
+func cancelFromHere(cancel context.CancelCauseFunc) {
+	cancel(errSibling)
+}
+
+func TestWithCancel_RecordsCanceler(t *testing.T) {
+	ctx, cancel := WithCancel(context.Background(), "printGreeting")
+	cancelFromHere(cancel)
+
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Errorf("expected ctx.Err() to stay context.Canceled, got %v", ctx.Err())
+	}
+	cause := CauseOf(ctx)
+	if cause == nil || cause.Name != "printGreeting" || !errors.Is(cause, errSibling) {
+		t.Fatalf("expected a cause naming the sibling failure, got %v", cause)
+	}
+	if stack := cause.Stack(); !strings.HasPrefix(stack, "github.com/yugant007/advanced-golang-concurrency/ctxutil.cancelFromHere") {
+		t.Errorf("expected the stack to start at the canceler, got %s", stack)
+	}
+}
+
+func TestWithCancel_NilMeansCanceled(t *testing.T) {
+	ctx, cancel := WithCancel(context.Background(), "shutdown")
+	cancel(nil)
+	if cause := CauseOf(ctx); cause == nil || cause.Err != context.Canceled {
+		t.Errorf("expected context.Canceled as the cause, got %v", cause)
+	}
+}
+
+func TestWithTimeout_RecordsWhereTimeoutWasSet(t *testing.T) {
+	ctx, cancel := WithTimeout(context.Background(), "genGreeting", time.Millisecond)
+	defer cancel(nil)
+	<-ctx.Done()
+
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Errorf("expected ctx.Err() to be context.DeadlineExceeded, got %v", ctx.Err())
+	}
+	cause := CauseOf(ctx)
+	if cause == nil || cause.Name != "genGreeting" || !errors.Is(cause, context.DeadlineExceeded) {
+		t.Fatalf("expected a timeout cause, got %v", cause)
+	}
+	if stack := cause.Stack(); !strings.Contains(stack, "TestWithTimeout_RecordsWhereTimeoutWasSet") || strings.Contains(stack, "ctxutil.WithTimeout") {
+		t.Errorf("expected the stack to start at the caller of WithTimeout, got %s", stack)
+	}
+}
+
+func TestCauseOf_PropagatesToChildren(t *testing.T) {
+	parent, cancel := WithCancel(context.Background(), "request")
+	child, childCancel := context.WithTimeout(parent, time.Minute)
+	defer childCancel()
+
+	cancel(errSibling)
+	if cause := CauseOf(child); cause == nil || cause.Name != "request" {
+		t.Errorf("expected the child to report the parent's cause, got %v", cause)
+	}
+	if CauseOf(context.Background()) != nil {
+		t.Errorf("expected no cause for a live context")
+	}
+}
+
+func TestRegistry_DumpsLiveTree(t *testing.T) {
+	r := NewRegistry()
+	root, cancelRoot := r.WithCancel(context.Background(), "main")
+	greeting, cancelGreeting := r.WithTimeout(root, "printGreeting", time.Minute)
+	locale, cancelLocale := r.WithCancel(greeting, "locale")
+	farewell, cancelFarewell := r.WithCancel(root, "printFarewell")
+	_, _ = locale, farewell
+
+	cancelFarewell(errSibling) //Released, so it leaves the tree.
+	cancelGreeting(errSibling) //Released too, but locale still hangs on to it.
+
+	var out bytes.Buffer
+	r.Dump(&out)
+	expected := "main\n" +
+		"  printGreeting [deadline in 1m0s] done: printGreeting: sibling failed\n" +
+		"    locale [deadline in 1m0s] done: printGreeting: sibling failed\n"
+	if got := out.String(); got != expected {
+		t.Errorf("expected dump:\n%s\nbut received:\n%s", expected, got)
+	}
+
+	cancelLocale(nil)
+	cancelRoot(nil)
+	out.Reset()
+	r.Dump(&out)
+	if out.Len() != 0 {
+		t.Errorf("expected an empty tree once everything is released, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,64 @@
+// Package ctxutil holds helpers for carrying values, deadlines and cancellation through a context.Context.
+package ctxutil
+
+import (
+	"context"
+	"fmt"
+)
+
+// Key is a typed context key. Every Key is its own unexported identity, so two keys never collide even when
+// they share a name, and the value type travels with the key instead of living in a type assertion.
+type Key[T any] struct {
+	name       string
+	def        T
+	hasDefault bool
+}
+
+// NewKey returns a key with no default. name is only for messages and for code, like ctxwire, that
+// has to refer to the key from outside the program.
+func NewKey[T any](name string) *Key[T] {
+	return &Key[T]{name: name}
+}
+
+// NewKeyWithDefault returns a key whose Get, Value and MustGet fall back to def when nothing was stored.
+func NewKeyWithDefault[T any](name string, def T) *Key[T] {
+	return &Key[T]{name: name, def: def, hasDefault: true}
+}
+
+// Name is the name the key was created with.
+func (k *Key[T]) Name() string {
+	return k.name
+}
+
+// With returns a copy of ctx carrying v under k.
+func (k *Key[T]) With(ctx context.Context, v T) context.Context {
+	return context.WithValue(ctx, k, v)
+}
+
+// Get returns the value stored under k, or the key's default (or T's zero value) and false if there isn't one.
+func (k *Key[T]) Get(ctx context.Context) (T, bool) {
+	v, ok := ctx.Value(k).(T)
+	if !ok {
+		return k.def, false
+	}
+	return v, true
+}
+
+// Value is Get for callers happy with the default when nothing was stored.
+func (k *Key[T]) Value(ctx context.Context) T {
+	v, _ := k.Get(ctx)
+	return v
+}
+
+// MustGet panics if nothing was stored under k and k has no default. Use it where a missing value is a bug.
+func (k *Key[T]) MustGet(ctx context.Context) T {
+	v, ok := k.Get(ctx)
+	if !ok && !k.hasDefault {
+		panic(fmt.Sprintf("ctxutil: no value for context key %q", k.name))
+	}
+	return v
+}
+
+func (k *Key[T]) String() string {
+	return "ctxutil.Key(" + k.name + ")"
+}
@@ -0,0 +1,58 @@
+package ctxutil
+
+import (
+	"context"
+	"testing"
+)
+
+func TestKey_WithAndGet(t *testing.T) {
+	userID := NewKey[string]("userID")
+	ctx := userID.With(context.Background(), "jane")
+
+	if v, ok := userID.Get(ctx); !ok || v != "jane" {
+		t.Errorf("expected jane, but received %q (%v)", v, ok)
+	}
+	if v := userID.MustGet(ctx); v != "jane" {
+		t.Errorf("expected jane, but received %q", v)
+	}
+}
+
+func TestKey_MissingValue(t *testing.T) {
+	userID := NewKey[string]("userID")
+	if v, ok := userID.Get(context.Background()); ok || v != "" {
+		t.Errorf("expected zero value and false, but received %q (%v)", v, ok)
+	}
+
+	defer func() {
+		if r := recover(); r != `ctxutil: no value for context key "userID"` {
+			t.Errorf("expected MustGet to panic naming the key, got %v", r)
+		}
+	}()
+	userID.MustGet(context.Background())
+}
+
+func TestKey_Default(t *testing.T) {
+	locale := NewKeyWithDefault("locale", "EN/US")
+	if v, ok := locale.Get(context.Background()); ok || v != "EN/US" {
+		t.Errorf("expected default and false, but received %q (%v)", v, ok)
+	}
+	if v := locale.MustGet(context.Background()); v != "EN/US" {
+		t.Errorf("expected MustGet to fall back on the default, got %q", v)
+	}
+	if v := locale.Value(locale.With(context.Background(), "FR/FR")); v != "FR/FR" {
+		t.Errorf("expected stored value to win over default, got %q", v)
+	}
+}
+
+func TestKey_DistinctKeysDoNotCollide(t *testing.T) {
+	a, b := NewKey[string]("id"), NewKey[string]("id")
+	ctx := a.With(context.Background(), "from a")
+	if _, ok := b.Get(ctx); ok {
+		t.Errorf("expected keys with the same name to stay distinct")
+	}
+
+	n := NewKey[int]("count")
+	if v := n.Value(n.With(ctx, 3)); v != 3 {
+		t.Errorf("expected 3, but received %v", v)
+	}
+}
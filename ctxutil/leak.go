@@ -0,0 +1,123 @@
+package ctxutil
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Factory derives cancelable contexts. Code that takes a Factory instead of calling the context package
+// directly can be handed a LeakChecker in tests.
+type Factory interface {
+	WithCancel(parent context.Context) (context.Context, context.CancelFunc)
+	WithTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc)
+	WithDeadline(parent context.Context, deadline time.Time) (context.Context, context.CancelFunc)
+}
+
+// Std is the Factory to use outside of tests; it is the context package itself.
+var Std Factory = stdFactory{}
+
+type stdFactory struct{}
+
+func (stdFactory) WithCancel(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithCancel(parent)
+}
+
+func (stdFactory) WithTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, timeout)
+}
+
+func (stdFactory) WithDeadline(parent context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	return context.WithDeadline(parent, deadline)
+}
+
+// TB is the part of testing.TB a LeakChecker needs.
+type TB interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+	Cleanup(func())
+}
+
+// LeakChecker is a Factory for tests. It remembers every context it hands out and, when the test finishes,
+// fails it for each one whose CancelFunc was never called, saying where that context was created.
+type LeakChecker struct {
+	t TB
+
+	mu   sync.Mutex
+	open map[*createdAt]bool // Whether Check has already reported the context, so it isn't reported again at the end.
+}
+
+type createdAt struct {
+	pcs []uintptr
+}
+
+func (c *createdAt) String() string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(c.pcs)
+	for {
+		frame, more := frames.Next()
+		if strings.HasPrefix(frame.Function, "testing.") || frame.Function == "runtime.goexit" {
+			return b.String() //The test harness's own frames don't help anyone find the leak.
+		}
+		fmt.Fprintf(&b, "\t%s\n\t\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			return b.String()
+		}
+	}
+}
+
+func NewLeakChecker(t TB) *LeakChecker {
+	c := &LeakChecker{t: t, open: make(map[*createdAt]bool)}
+	t.Cleanup(c.Check)
+	return c
+}
+
+func (c *LeakChecker) WithCancel(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	return ctx, c.track(cancel)
+}
+
+func (c *LeakChecker) WithTimeout(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	return ctx, c.track(cancel)
+}
+
+func (c *LeakChecker) WithDeadline(parent context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithDeadline(parent, deadline)
+	return ctx, c.track(cancel)
+}
+
+func (c *LeakChecker) track(cancel context.CancelFunc) context.CancelFunc {
+	pcs := make([]uintptr, 16)
+	site := &createdAt{pcs: pcs[:runtime.Callers(3, pcs)]} //Skip Callers, track and the With* method, so the trace starts at the code that asked for the context.
+
+	c.mu.Lock()
+	c.open[site] = false
+	c.mu.Unlock()
+
+	return func() {
+		cancel()
+		c.mu.Lock()
+		delete(c.open, site)
+		c.mu.Unlock()
+	}
+}
+
+// Check fails the test for every context whose CancelFunc hasn't been called yet and that an earlier Check
+// hasn't already reported. NewLeakChecker arranges for it to run when the test ends; calling it earlier is
+// only needed to check part way through.
+func (c *LeakChecker) Check() {
+	c.t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for site, reported := range c.open {
+		if reported {
+			continue
+		}
+		c.open[site] = true
+		c.t.Errorf("context created here was never canceled; its timer and anything waiting on it leak:\n%s", site)
+	}
+}
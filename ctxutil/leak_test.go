@@ -0,0 +1,98 @@
+package ctxutil
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeTB struct {
+	errors   []string
+	cleanups []func()
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(format string, args ...interface{}) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeTB) Cleanup(fn func()) {
+	f.cleanups = append(f.cleanups, fn)
+}
+
+func (f *fakeTB) finish() {
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+}
+
+func genGreeting(contexts Factory, ctx context.Context) {
+	ctx, cancel := contexts.WithTimeout(ctx, time.Second)
+	defer cancel()
+	<-ctx.Done()
+}
+
+func genGreetingForgetfully(contexts Factory, ctx context.Context) {
+	ctx, _ = contexts.WithTimeout(ctx, time.Second)
+	_ = ctx
+}
+
+func TestLeakChecker_PassesWhenEverythingIsCanceled(t *testing.T) {
+	tb := &fakeTB{}
+	contexts := NewLeakChecker(tb)
+
+	ctx, cancel := contexts.WithCancel(context.Background())
+	go cancel()
+	genGreeting(contexts, ctx)
+
+	tb.finish()
+	if len(tb.errors) != 0 {
+		t.Errorf("expected no leaks, got %v", tb.errors)
+	}
+}
+
+func TestLeakChecker_ReportsForgottenCancel(t *testing.T) {
+	tb := &fakeTB{}
+	contexts := NewLeakChecker(tb)
+
+	genGreetingForgetfully(contexts, context.Background())
+	_, cancel := contexts.WithDeadline(context.Background(), time.Now().Add(time.Minute))
+	cancel()
+
+	tb.finish()
+	if len(tb.errors) != 1 {
+		t.Fatalf("expected exactly one leak, got %v", tb.errors)
+	}
+	report := tb.errors[0]
+	if !strings.Contains(report, "never canceled") || !strings.Contains(report, "ctxutil.genGreetingForgetfully") {
+		t.Errorf("expected the report to point at genGreetingForgetfully, got:\n%s", report)
+	}
+	if strings.Contains(report, "(*LeakChecker)") || strings.Contains(report, "testing.tRunner") {
+		t.Errorf("expected checker and harness frames to be left out, got:\n%s", report)
+	}
+}
+
+func TestLeakChecker_ReportsEachLeakOnce(t *testing.T) {
+	tb := &fakeTB{}
+	contexts := NewLeakChecker(tb)
+
+	genGreetingForgetfully(contexts, context.Background())
+	contexts.Check() //Part way through the test.
+	genGreetingForgetfully(contexts, context.Background())
+
+	tb.finish()
+	if len(tb.errors) != 2 {
+		t.Fatalf("expected each of the two leaks reported once, got %d reports: %v", len(tb.errors), tb.errors)
+	}
+}
+
+func TestStd_IsTheContextPackage(t *testing.T) {
+	ctx, cancel := Std.WithTimeout(context.Background(), time.Minute)
+	cancel()
+	if ctx.Err() != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", ctx.Err())
+	}
+}
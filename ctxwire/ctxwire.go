@@ -0,0 +1,242 @@
+// Package ctxwire carries a request's context across a TCP connection: its deadline, its cancellation and
+// selected values such as the user and request IDs.
+//
+// Every message is a frame: a one-byte frame type, a four-byte big-endian length, and that many bytes of
+// payload. A call is one request frame from the client, optionally followed by a cancel frame, and one
+// response frame from the server.
+package ctxwire
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/yugant007/advanced-golang-concurrency/ctxutil"
+)
+
+var (
+	UserIDKey    = ctxutil.NewKey[string]("userID")
+	RequestIDKey = ctxutil.NewKey[string]("requestID")
+
+	DefaultKeys = []*ctxutil.Key[string]{UserIDKey, RequestIDKey}
+)
+
+const (
+	frameRequest byte = iota + 1
+	frameCancel
+	frameResponse
+)
+
+const maxFrameSize = 16 << 20
+
+const defaultRequestTimeout = 10 * time.Second
+
+type request struct {
+	Timeout time.Duration     `json:"timeout,omitempty"` //We send how long is left rather than the deadline itself, so the two machines' clocks don't have to agree.
+	Values  map[string]string `json:"values,omitempty"`
+	Body    []byte            `json:"body"`
+}
+
+type response struct {
+	Body  []byte `json:"body,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// RemoteError is what a client gets back when the server's handler returned an error.
+type RemoteError struct {
+	Message string
+}
+
+func (err RemoteError) Error() string {
+	return err.Message
+}
+
+type Handler func(ctx context.Context, body []byte) ([]byte, error)
+
+type Server struct {
+	Handler Handler
+	Keys    []*ctxutil.Key[string] // The values to restore on the server side. Defaults to DefaultKeys.
+	Base    context.Context        // Every request context derives from this. Canceling it stops all in-flight work, closes every connection and ends Serve.
+
+	RequestTimeout time.Duration // How long a new connection has to send its request frame. Defaults to ten seconds.
+}
+
+// Serve accepts connections on l until l fails or Base is done, in which case it closes l and returns Base's error.
+func (s *Server) Serve(l net.Listener) error {
+	base := s.base()
+	stop := context.AfterFunc(base, func() { l.Close() }) //Here we unblock Accept; nothing else would wake it up.
+	defer stop()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if base.Err() != nil {
+				return base.Err()
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+func (s *Server) ServeConn(conn net.Conn) {
+	defer conn.Close()
+	base := s.base()
+	stop := context.AfterFunc(base, func() { conn.Close() }) //Closing the connection is the only way to unblock a read that's already waiting.
+	defer stop()
+	r := bufio.NewReader(conn)
+
+	timeout := s.RequestTimeout
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout)) //A client that connects and then says nothing doesn't get to hold on to a goroutine and a socket forever.
+	kind, payload, err := readFrame(r)
+	if err != nil || kind != frameRequest {
+		return
+	}
+	conn.SetReadDeadline(time.Time{}) //From here on the request's own deadline is in charge, and the client may wait as long as it likes before canceling.
+	var req request
+	if err := json.Unmarshal(payload, &req); err != nil {
+		_ = writeJSONFrame(conn, frameResponse, response{Error: fmt.Sprintf("malformed request: %v", err)})
+		return
+	}
+
+	ctx, cancel := s.requestContext(base, req)
+	defer cancel()
+
+	go func() {
+		readFrame(r) //Whatever comes next, a cancel frame, EOF because the client hung up, or a broken connection, it means nobody is waiting for our answer any more.
+		cancel()
+	}()
+
+	body, err := s.Handler(ctx, req.Body)
+	resp := response{Body: body}
+	if err != nil {
+		resp = response{Error: err.Error()}
+	}
+	_ = writeJSONFrame(conn, frameResponse, resp)
+}
+
+func (s *Server) base() context.Context {
+	if s.Base == nil {
+		return context.Background()
+	}
+	return s.Base
+}
+
+func (s *Server) requestContext(ctx context.Context, req request) (context.Context, context.CancelFunc) {
+	keys := s.Keys
+	if keys == nil {
+		keys = DefaultKeys
+	}
+	for _, key := range keys {
+		if v, ok := req.Values[key.Name()]; ok {
+			ctx = key.With(ctx, v)
+		}
+	}
+	if req.Timeout > 0 {
+		return context.WithTimeout(ctx, req.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+type Client struct {
+	Keys   []*ctxutil.Key[string] // The values to send along with each call. Defaults to DefaultKeys.
+	Dialer net.Dialer
+}
+
+func (c *Client) Call(ctx context.Context, network, addr string, body []byte) ([]byte, error) {
+	conn, err := c.Dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := request{Body: body, Values: make(map[string]string)}
+	if deadline, ok := ctx.Deadline(); ok {
+		if req.Timeout = time.Until(deadline); req.Timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+	}
+	keys := c.Keys
+	if keys == nil {
+		keys = DefaultKeys
+	}
+	for _, key := range keys {
+		if v, ok := key.Get(ctx); ok {
+			req.Values[key.Name()] = v
+		}
+	}
+
+	if err := writeJSONFrame(conn, frameRequest, req); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			_ = writeFrame(conn, frameCancel, nil) //Here we tell the server to stop, then hang up so we stop waiting too.
+			conn.Close()
+		}
+	}()
+
+	kind, payload, err := readFrame(bufio.NewReader(conn))
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if kind != frameResponse {
+		return nil, fmt.Errorf("ctxwire: unexpected frame type %d", kind)
+	}
+	var resp response
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, RemoteError{Message: resp.Error}
+	}
+	return resp.Body, nil
+}
+
+func writeJSONFrame(w io.Writer, kind byte, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFrame(w, kind, payload)
+}
+
+func writeFrame(w io.Writer, kind byte, payload []byte) error {
+	header := make([]byte, 5)
+	header[0] = kind
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	_, err := w.Write(append(header, payload...)) //One write per frame, so a frame is never interleaved with another.
+	return err
+}
+
+func readFrame(r io.Reader) (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxFrameSize {
+		return 0, nil, errors.New("ctxwire: frame too large")
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
@@ -0,0 +1,191 @@
+package ctxwire
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func startServer(t *testing.T, handler Handler) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go (&Server{Handler: handler}).Serve(l)
+	return l.Addr().String()
+}
+
+func TestCall_PropagatesValuesAndDeadline(t *testing.T) {
+	type seen struct {
+		userID, requestID string
+		remaining         time.Duration
+	}
+	seenCh := make(chan seen, 1)
+	addr := startServer(t, func(ctx context.Context, body []byte) ([]byte, error) {
+		deadline, _ := ctx.Deadline()
+		seenCh <- seen{UserIDKey.Value(ctx), RequestIDKey.Value(ctx), time.Until(deadline)}
+		return append([]byte("echo: "), body...), nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ctx = UserIDKey.With(ctx, "jane")
+	ctx = RequestIDKey.With(ctx, "req-1")
+
+	body, err := (&Client{}).Call(ctx, "tcp", addr, []byte("hi"))
+	if err != nil || string(body) != "echo: hi" {
+		t.Fatalf("unexpected response %q, %v", body, err)
+	}
+
+	got := <-seenCh
+	if got.userID != "jane" || got.requestID != "req-1" {
+		t.Errorf("expected values to reach the server, got %+v", got)
+	}
+	if got.remaining <= time.Second || got.remaining > 2*time.Second {
+		t.Errorf("expected roughly two seconds left on the server, got %v", got.remaining)
+	}
+}
+
+func TestCall_ReturnsHandlerErrors(t *testing.T) {
+	addr := startServer(t, func(ctx context.Context, body []byte) ([]byte, error) {
+		return nil, errors.New("no such job")
+	})
+
+	_, err := (&Client{}).Call(context.Background(), "tcp", addr, nil)
+	var remote RemoteError
+	if !errors.As(err, &remote) || remote.Message != "no such job" {
+		t.Errorf("expected a RemoteError, got %v", err)
+	}
+}
+
+func TestCall_CancellationReachesServer(t *testing.T) {
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+	addr := startServer(t, func(ctx context.Context, body []byte) ([]byte, error) {
+		close(started)
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return nil, ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, err := (&Client{}).Call(ctx, "tcp", addr, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the client to see its own cancellation, got %v", err)
+	}
+
+	select {
+	case err := <-stopped:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected server context to be canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server-side work was never canceled")
+	}
+}
+
+func TestServer_ClientHangUpCancelsWork(t *testing.T) {
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	addr := startServer(t, func(ctx context.Context, body []byte) ([]byte, error) {
+		close(started)
+		<-ctx.Done()
+		close(stopped)
+		return nil, nil
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJSONFrame(conn, frameRequest, request{Body: []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	conn.Close() //No cancel frame: the client process simply went away.
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("server-side work was never canceled")
+	}
+}
+
+func TestServer_DeadlineExpiresOnServer(t *testing.T) {
+	addr := startServer(t, func(ctx context.Context, body []byte) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	writeJSONFrame(conn, frameRequest, request{Timeout: 50 * time.Millisecond})
+
+	kind, payload, err := readFrame(conn)
+	if err != nil || kind != frameResponse || string(payload) != `{"error":"context deadline exceeded"}` {
+		t.Errorf("expected a deadline error response, got %d %s %v", kind, payload, err)
+	}
+}
+
+func TestServer_DropsSilentClients(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go (&Server{Handler: func(ctx context.Context, body []byte) ([]byte, error) { return nil, nil }, RequestTimeout: 50 * time.Millisecond}).Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) { //We never send a request, so the server should hang up on us.
+		t.Fatalf("expected the server to close a connection that never sent a request, got %v", err)
+	}
+}
+
+func TestServer_BaseCancellationStopsEverything(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- (&Server{Handler: func(ctx context.Context, body []byte) ([]byte, error) { return nil, nil }, Base: base}).Serve(l)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(10 * time.Millisecond) //Let the server start waiting for our request frame.
+	cancel()
+
+	select {
+	case err := <-served:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected Serve to return the base context's error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve kept running after Base was canceled")
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the idle connection to be closed when Base was canceled, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// run -> go test errgroup_test.go errgroup.go example-4.go
+
+type groupResult struct { //Just like the Result type from example-3.go, each result carries its value and its error together, plus where it came from.
+	Index int
+	Value interface{}
+	Err   error
+}
+
+func newErrGroup(ctx context.Context, limit, maxErrors int) (*errGroup, context.Context) {
+	if maxErrors < 1 {
+		maxErrors = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	g := &errGroup{ctx: ctx, cancel: cancel, maxErrors: maxErrors}
+	if limit > 0 {
+		g.sem = make(chan struct{}, limit) //Here we cap how many goroutines run at once; a nil sem means no cap.
+	}
+	return g, ctx
+}
+
+type errGroup struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	sem       chan struct{}
+	maxErrors int
+
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	results []groupResult
+	errs    []error
+}
+
+func (g *errGroup) Go(fn func(ctx context.Context) (interface{}, error)) {
+	g.mu.Lock()
+	index := len(g.results)
+	g.results = append(g.results, groupResult{Index: index})
+	g.mu.Unlock()
+
+	if err := g.ctx.Err(); err != nil { //Once the group has been canceled there's no point starting more work; we record why it never ran.
+		g.record(index, nil, err)
+		return
+	}
+	if g.sem != nil {
+		select {
+		case <-g.ctx.Done():
+			g.record(index, nil, g.ctx.Err())
+			return
+		case g.sem <- struct{}{}: //Go blocks here when the group is at its limit, so the caller feels the back-pressure.
+		}
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if g.sem != nil {
+			defer func() { <-g.sem }()
+		}
+		value, err := fn(g.ctx)
+		g.record(index, value, err)
+	}()
+}
+
+func (g *errGroup) record(index int, value interface{}, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.results[index].Value = value
+	g.results[index].Err = err
+	if err == nil {
+		return
+	}
+	g.errs = append(g.errs, err)
+	if len(g.errs) == g.maxErrors { //Here we cancel every sibling as soon as we've seen as many errors as we're willing to tolerate.
+		g.cancel()
+	}
+}
+
+func (g *errGroup) Wait() ([]groupResult, error) {
+	g.wg.Wait()
+	g.cancel()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	results := append([]groupResult(nil), g.results...)
+	if len(g.errs) == 0 {
+		return results, nil
+	}
+	return results, &multiError{errs: append([]error(nil), g.errs...)}
+}
+
+type multiError struct { //The errors are kept exactly as they were returned, so a MyError still has its stack trace and Misc map.
+	errs []error
+}
+
+func (m *multiError) Error() string {
+	if len(m.errs) == 1 {
+		return m.errs[0].Error()
+	}
+	msgs := make([]string, len(m.errs))
+	for i, err := range m.errs {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors occurred: %s", len(m.errs), strings.Join(msgs, "; "))
+}
+
+func (m *multiError) Errors() []error {
+	return m.errs // In the order they occurred; the first one is usually the one that caused the cancellation.
+}
+
+func (m *multiError) Unwrap() []error {
+	return m.errs
+}
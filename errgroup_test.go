@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestErrGroup_CollectsResultsInOrder(t *testing.T) {
+	g, _ := newErrGroup(context.Background(), 2, 1)
+	for i := 0; i < 5; i++ {
+		i := i
+		g.Go(func(ctx context.Context) (interface{}, error) {
+			time.Sleep(time.Duration(5-i) * time.Millisecond)
+			return i * i, nil
+		})
+	}
+
+	results, err := g.Wait()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, r := range results {
+		if r.Index != i || r.Value != i*i {
+			t.Errorf("index %v: expected %v, but received %+v", i, i*i, r)
+		}
+	}
+}
+
+func TestErrGroup_LimitsConcurrency(t *testing.T) {
+	var running, peak int32
+	g, _ := newErrGroup(context.Background(), 3, 1)
+	for i := 0; i < 20; i++ {
+		g.Go(func(ctx context.Context) (interface{}, error) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil, nil
+		})
+	}
+	g.Wait()
+
+	if peak > 3 {
+		t.Errorf("expected at most 3 goroutines at once, saw %v", peak)
+	}
+}
+
+func TestErrGroup_FirstErrorCancelsSiblings(t *testing.T) {
+	g, _ := newErrGroup(context.Background(), 0, 1)
+	g.Go(func(ctx context.Context) (interface{}, error) {
+		return nil, runJob("1")
+	})
+	g.Go(func(ctx context.Context) (interface{}, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return "finished", nil
+		}
+	})
+
+	results, err := g.Wait()
+	if !errors.Is(results[1].Err, context.Canceled) {
+		t.Errorf("expected sibling to be canceled, got %+v", results[1])
+	}
+
+	var multi *multiError
+	if !errors.As(err, &multi) || len(multi.Errors()) != 2 {
+		t.Fatalf("expected a multiError with 2 errors, got %v", err)
+	}
+	var myErr MyError
+	if !errors.As(multi.Errors()[0], &myErr) || len(myErr.StackTrace) == 0 {
+		t.Errorf("expected first error to keep its MyError details, got %#v", multi.Errors()[0])
+	}
+	if !errors.Is(err, IntermediateErr{}) {
+		t.Errorf("expected errors.Is to see through the multiError")
+	}
+}
+
+func TestErrGroup_ToleratesUpToMaxErrors(t *testing.T) {
+	g, ctx := newErrGroup(context.Background(), 1, 3)
+	for i := 0; i < 5; i++ {
+		i := i
+		g.Go(func(ctx context.Context) (interface{}, error) {
+			return nil, fmt.Errorf("url %d: no such host", i)
+		})
+	}
+
+	results, err := g.Wait()
+	if ctx.Err() == nil {
+		t.Errorf("expected group context to be canceled")
+	}
+	if len(results) != 5 {
+		t.Fatalf("expected a result for every call to Go, got %v", len(results))
+	}
+	if got := results[2].Err; got == nil || got.Error() != "url 2: no such host" {
+		t.Errorf("expected third call to run before cancellation, got %v", got)
+	}
+	if !errors.Is(results[4].Err, context.Canceled) {
+		t.Errorf("expected calls after the third error to be skipped, got %v", results[4].Err)
+	}
+	if err == nil {
+		t.Errorf("expected a multiError")
+	}
+}
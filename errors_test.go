@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// run -> go test errors_test.go example-4.go
+
+func TestRunJob_UnwrapsToPathError(t *testing.T) {
+	err := runJob("1")
+
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected errors.Is to reach os.ErrNotExist, got %#v", err)
+	}
+
+	var pathErr *os.PathError
+	if !errors.As(err, &pathErr) {
+		t.Fatalf("expected errors.As to find *os.PathError")
+	}
+	if pathErr.Path != "/bad/job/binary" {
+		t.Errorf("expected path %q, but received %q", "/bad/job/binary", pathErr.Path)
+	}
+}
+
+func TestRunJob_MatchesBoundarySentinels(t *testing.T) {
+	err := fmt.Errorf("cli: %w", runJob("1"))
+
+	if !errors.Is(err, IntermediateErr{}) {
+		t.Errorf("expected chain to contain IntermediateErr")
+	}
+	if !errors.Is(err, LowLevelErr{}) {
+		t.Errorf("expected chain to contain LowLevelErr")
+	}
+
+	var intermediate IntermediateErr
+	if !errors.As(err, &intermediate) {
+		t.Fatalf("expected errors.As to find IntermediateErr")
+	}
+	if msg := intermediate.Error(); msg != `cannot run job "1": requisite binaries not available` {
+		t.Errorf("unexpected message %q", msg)
+	}
+
+	var myErr MyError
+	if !errors.As(err, &myErr) || len(myErr.StackTrace) == 0 {
+		t.Errorf("expected errors.As to find a MyError with a stack trace")
+	}
+}
+
+func TestCrossedBoundaries(t *testing.T) {
+	got := crossedBoundaries(fmt.Errorf("cli: %w", runJob("1")))
+	if expected := []string{"intermediate", "lowlevel"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, but received %v", expected, got)
+	}
+
+	if got := crossedBoundaries(errors.New("raw")); len(got) != 0 {
+		t.Errorf("expected no boundaries for a raw error, got %v", got)
+	}
+}
+
+func TestWrapError_PercentW(t *testing.T) {
+	err := wrapError(nil, "cannot open config: %w", os.ErrPermission)
+	if !errors.Is(err, os.ErrPermission) {
+		t.Errorf("expected %%w argument to become Inner")
+	}
+	if err.Message != "cannot open config: permission denied" {
+		t.Errorf("unexpected message %q", err.Message)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expected errClass
+	}{
+		{"intermediate", runJob("1"), errClassWellFormed},
+		{"rewrapped intermediate", fmt.Errorf("cli: %w", runJob("1")), errClassWellFormed},
+		{"bare MyError", wrapError(nil, "cannot run job %q: requisite binaries are not executable", "1"), errClassBug},
+		{"low level only", LowLevelErr{wrapError(os.ErrNotExist, "stat failed")}, errClassBug},
+		{"raw", errors.New("exit status 1"), errClassBug},
+	} {
+		if got := classifyError(tc.err); got != tc.expected {
+			t.Errorf("%s: expected %v, but received %v", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestErrorReporter_CountsAndReportsByClass(t *testing.T) {
+	var out bytes.Buffer
+	reporter := newErrorReporter(&out)
+	reporter.CaptureContextValue("userID", "userID")
+
+	wellFormed, bugs := errClassCount(errClassWellFormed), errClassCount(errClassBug)
+
+	ctx := context.WithValue(context.Background(), "userID", "jane")
+	logID, message := reporter.Report(ctx, runJob("1"))
+	if message != `cannot run job "1": requisite binaries not available` {
+		t.Errorf("unexpected well-formed message %q", message)
+	}
+
+	bugID, bugMessage := reporter.Report(ctx, wrapError(nil, "not executable"))
+	if !strings.Contains(bugMessage, bugID) {
+		t.Errorf("expected bug message to quote log ID %q, got %q", bugID, bugMessage)
+	}
+	if logID == bugID {
+		t.Errorf("expected distinct log IDs, got %q twice", logID)
+	}
+
+	if got := errClassCount(errClassWellFormed) - wellFormed; got != 1 {
+		t.Errorf("expected 1 well-formed error counted, got %v", got)
+	}
+	if got := errClassCount(errClassBug) - bugs; got != 1 {
+		t.Errorf("expected 1 bug counted, got %v", got)
+	}
+
+	dec := json.NewDecoder(&out)
+	var report errorReport
+	if err := dec.Decode(&report); err != nil {
+		t.Fatalf("cannot decode report: %v", err)
+	}
+	if report.LogID != logID || report.Class != "well-formed" || report.Context["userID"] != "jane" {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if len(report.Chain) != 6 {
+		t.Fatalf("expected 6 links in chain, got %+v", report.Chain)
+	}
+	if report.Chain[0].Boundary != "intermediate" || report.Chain[2].Boundary != "lowlevel" {
+		t.Errorf("expected boundaries on wrapper links, got %+v", report.Chain)
+	}
+	if len(report.Chain[1].Stack) == 0 || report.Chain[1].Stack[0].Function == "" {
+		t.Errorf("expected stack frames on MyError link, got %+v", report.Chain[1])
+	}
+	if err := dec.Decode(&report); err != nil || report.Class != "bug" {
+		t.Errorf("expected second report to be a bug, got %+v (%v)", report, err)
+	}
+}
+
+func TestErrorReporter_ConcurrentReportsStayWhole(t *testing.T) {
+	var out bytes.Buffer
+	reporter := newErrorReporter(&out)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := wrapError(nil, "boom")
+			err.Misc["unencodable"] = make(chan int)
+			reporter.Report(context.Background(), err)
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var report errorReport
+		if err := json.Unmarshal([]byte(line), &report); err != nil {
+			t.Fatalf("cannot decode %q: %v", line, err)
+		}
+		if seen[report.LogID] {
+			t.Errorf("duplicate log ID %q", report.LogID)
+		}
+		seen[report.LogID] = true
+	}
+	if len(seen) != 50 {
+		t.Errorf("expected 50 reports, but received %v", len(seen))
+	}
+}
+
+func TestWrapError_StackStartsAtCaller(t *testing.T) {
+	frames := wrapError(nil, "boom").StackTrace.Frames()
+	if len(frames) == 0 {
+		t.Fatal("expected a stack trace")
+	}
+	if fn := frames[0].Function; !strings.HasSuffix(fn, "TestWrapError_StackStartsAtCaller") {
+		t.Errorf("expected first frame to be the caller, got %v", fn)
+	}
+}
+
+func TestWrapError_StackDepth(t *testing.T) {
+	defer func(depth int) { maxStackDepth = depth }(maxStackDepth)
+	maxStackDepth = 1
+
+	if got := len(wrapError(nil, "boom").StackTrace); got != 1 {
+		t.Errorf("expected 1 frame, but received %v", got)
+	}
+}
+
+func TestWireError_RoundTrip(t *testing.T) {
+	sent := runJob("1")
+	var myErr MyError
+	errors.As(sent, &myErr)
+	myErr.Misc["host"] = "runner-1"
+
+	data, err := marshalError(sent)
+	if err != nil {
+		t.Fatalf("cannot marshal: %v", err)
+	}
+	received, err := unmarshalError(data, "jobrunner:9000")
+	if err != nil {
+		t.Fatalf("cannot unmarshal: %v", err)
+	}
+
+	if received.Error() != sent.Error() {
+		t.Errorf("expected message %q, but received %q", sent.Error(), received.Error())
+	}
+	if !errors.Is(received, IntermediateErr{}) || !errors.Is(received, LowLevelErr{}) {
+		t.Errorf("expected decoded chain to match boundary sentinels")
+	}
+	if got := classifyError(received); got != errClassWellFormed {
+		t.Errorf("expected decoded error to classify as well-formed, got %v", got)
+	}
+
+	var decoded MyError
+	if !errors.As(received, &decoded) {
+		t.Fatalf("expected a MyError in the decoded chain")
+	}
+	if decoded.Misc["origin"] != "jobrunner:9000" || decoded.Misc["host"] != "runner-1" {
+		t.Errorf("unexpected misc %v", decoded.Misc)
+	}
+	if stack, _ := decoded.Misc["remoteStack"].([]string); len(stack) == 0 || !strings.Contains(stack[0], "runJob") {
+		t.Errorf("expected remote stack summary starting at runJob, got %v", decoded.Misc["remoteStack"])
+	}
+
+	var remote remoteError
+	if !errors.As(received, &remote) || remote.GoType != "*fs.PathError" {
+		t.Errorf("expected unregistered errors to arrive as remoteError, got %#v", remote)
+	}
+}
+
+func TestWireError_RejectsUnknownVersion(t *testing.T) {
+	if _, err := unmarshalError([]byte(`{"v":99,"error":{"message":"x"}}`), "remote"); err == nil {
+		t.Errorf("expected an error for an unknown version")
+	}
+}
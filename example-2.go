@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
 	"sync"
 	"time"
+
+	"github.com/yugant007/advanced-golang-concurrency/ctxwire"
 )
 
 func main() {
@@ -905,3 +908,37 @@ func startNetworkDaemon2() *sync.WaitGroup {
 	}()
 	return &wg
 }
+
+func startNetworkDaemon3() *sync.WaitGroup { //Like startNetworkDaemon2, but each connection carries a framed request, so the client's deadline, identity and cancellation reach our handler.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		connPool := warmServiceConnCache()
+
+		server, err := net.Listen("tcp", "localhost:8080")
+		if err != nil {
+			log.Fatalf("cannot listen: %v", err)
+		}
+		defer server.Close()
+
+		wg.Done()
+
+		daemon := &ctxwire.Server{
+			Handler: func(ctx context.Context, body []byte) ([]byte, error) {
+				svcConn := connPool.Get()
+				defer connPool.Put(svcConn)
+				select {
+				case <-ctx.Done(): //If the client hangs up or runs out of time, we stop too.
+					return nil, ctx.Err()
+				default:
+				}
+				userID, _ := ctxwire.UserIDKey.Get(ctx)
+				return []byte(fmt.Sprintf("hello %v", userID)), nil
+			},
+		}
+		if err := daemon.Serve(server); err != nil {
+			log.Printf("cannot accept connection: %v", err)
+		}
+	}()
+	return &wg
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"time"
+
+	"github.com/yugant007/advanced-golang-concurrency/ctxutil"
 )
 
 func main() {
@@ -193,6 +195,13 @@ func main() {
 	//We see now that the goroutine is being properly cleaned up.
 	//Now that we know how to ensure goroutines don’t leak, we can stipulate a convention: If a goroutine is
 	//responsible for creating a goroutine, it is also responsible for ensuring it can stop the goroutine.
+	//The pubsub package's Broker follows this convention. Each subscription runs a goroutine that ends it, closing its
+	//channel and leaving the broker, on Unsubscribe or when the broker's done channel is closed, even if a publisher
+	//is blocked on the subscriber at the time:
+	//broker := pubsub.NewBroker[string](done)
+	//audit, _ := broker.Subscribe("commands.>", 100, pubsub.AtMostOnce)
+	//defer audit.Unsubscribe()
+	//broker.Publish("commands.user.42", "delete")
 
 	//The or-channel
 	//At times you may find yourself wanting to combine one or more done channels into a single done channel
@@ -351,6 +360,30 @@ func main() {
 	//	}
 	//	fmt.Printf("Response: %v\n", result.Response.Status)
 	//}
+	//Writing this collection loop by hand for every caller gets old. errGroup in errgroup.go does it for us:
+	//every goroutine runs under a shared context, the group cancels the rest after the first error (or after
+	//maxErrors), and Wait hands back every result in submission order plus a multiError holding the errors
+	//untouched:
+	//g, _ := newErrGroup(context.Background(), 2, 3) //At most two requests in flight; give up after three errors.
+	//for _, url := range urls {
+	//	url := url
+	//	g.Go(func(ctx context.Context) (interface{}, error) {
+	//		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	//		if err != nil {
+	//			return nil, err
+	//		}
+	//		return http.DefaultClient.Do(req)
+	//	})
+	//}
+	//results, err := g.Wait()
+	//Inside a pipeline, the pipeline package carries this Result for us. Its stages pass Result{Value, Err} from one to
+	//the next, and each stage picks what happens to its own errors. Forward sends them on to the end, Report puts them
+	//on that stage's Errors channel, and CancelAll stops every stage. The Pipeline owns done and closes every channel:
+	//p := pipeline.New()
+	//urlStream := pipeline.From(p, "urls", urlChan)
+	//responses := pipeline.Map(urlStream, "check", pipeline.Options{Workers: 4, Buffer: 4, OnError: pipeline.Forward}, http.Get)
+	//for result := range responses.Out() { ... }
+	//err := p.Wait()
 
 	//Pipelines
 	//A pipeline is just another tool you can use to form an abstraction in your system. In particular, it is a
@@ -408,6 +441,13 @@ func main() {
 	//what we call batch processing. This just means that they operate on chunks of data all at once instead
 	//of one discrete value at a time. There is another type of pipeline stage that performs stream processing.
 	//This means that the stage receives and emits one element at a time.
+	//The two can be mixed: stages.Batch(done, in, 50, 10*time.Millisecond) turns a stream into slices of up to 50,
+	//sending early once the first value in a slice has waited 10ms, so a stage calling something that is far
+	//cheaper in bulk, like APIConnection3.ReadFile3, pays for one call per slice. stages.Unbatch turns it back.
+	//For metrics, stages.Tumbling, stages.Sliding and stages.Session group a stream into windows per key and fold
+	//each with a reduce function. They go by the time each item says it happened, and hold a window open until a
+	//watermark passes its end, so an item that is a little out of order still counts; one that arrives after its
+	//window was sent is handed to OnLate instead.
 	//multiply := func(value, multiplier int) int {
 	//	return value * multiplier
 	//}
@@ -648,6 +688,10 @@ func main() {
 	//overhead. If this technique still leaves a bad taste in your mouth, you can always write a Go
 	//generator for creating your generator stages. Speaking of one stage being computationally expensive,
 	//how can we help mitigate this? Won’t it rate-limit the entire pipeline?
+	//Since Go 1.18 there is a third option: type parameters. The stages package has repeat, repeatFn, take,
+	//orDone, tee, bridge, fanIn, fanOut, map and filter written once for any type, e.g.
+	//stages.Take(done, stages.Repeat(done, "a"), 10) is a <-chan string with no interface{} in sight.
+	//BenchmarkGenericStages in benchmark_4_test.go runs as fast as BenchmarkTyped.
 	//For ways to help mitigate this, let’s discuss the fan-out, fan-in technique.
 
 	//Fan-Out, Fan-In
@@ -817,6 +861,13 @@ func main() {
 	//A naive implementation of the fan-in, fan-out algorithm only works if the order in which results arrive is
 	//unimportant. We have done nothing to guarantee that the order in which items are read from the randIntStream
 	//is preserved as it makes its way through the sieve. Later, we’ll look at an example of a way to maintain order.
+	//One way is stages.ParallelMap(done, randIntStream, numFinders, window, isPrime): it numbers each item on the way
+	//in and holds finished results back until everything before them has gone out. Only window items may be in
+	//flight at once, so a slow item stalls the stage rather than growing the reorder buffer without bound.
+	//stages.ParallelMapUnordered is the plain fan-out, fan-in above, for when order doesn't matter.
+	//Often we only need order per key, say all the events for one user. stages.Partition routes each item to a worker
+	//by a consistent hash of its key, so a user's events stay in order while different users run in parallel, and
+	//Resize changes the number of workers without losing anything a worker was already given.
 	//So down from 3 seconds to 1 seconds, not bad!
 
 	//The or-done-channel
@@ -911,6 +962,13 @@ func main() {
 	//for val1 := range out1 {
 	//	fmt.Printf("out1: %v, out2: %v\n", val1, <-out2)
 	//}
+	//That coupling is a problem when one reader is an auditor that falls behind: the executor waits for it. stages.TeeN
+	//gives each output its own policy instead: Block (the behaviour above), DropNewest, DropOldest, which keeps a ring
+	//of the latest values, or FailWhenFull, which closes that output and reports ErrOutputFull once it is a buffer
+	//behind. Each Output counts what it dropped:
+	//outs := stages.TeeN(done, commands, stages.Branch{Policy: stages.Block}, stages.Branch{Policy: stages.DropOldest, Buffer: 100})
+	//go audit(outs[1].C)
+	//for cmd := range outs[0].C { execute(cmd) }
 
 	//The bridge-channel
 	//In some circumstances, you may find yourself wanting to consume values from a sequence of channels:
@@ -967,6 +1025,11 @@ func main() {
 	//}
 	//Thanks to bridge, we can use the channel of channels from within a single range statement and focus
 	//on our loop’s logic. Destructuring the channel of channels is left to code that is specific to this concern.
+	//Once a pipeline has a few of these, tee here, fan-in there and a bridge at the end, the wiring gets hard to
+	//follow. The pipeline package has Tee, Merge and Bridge stages, and a running Pipeline can draw itself:
+	//p.WriteDOT(f) writes a Graphviz graph with a node per stage and an edge per channel, labeled with its buffer
+	//and how many items a second went through it since the last call. The dotted edges from done show that every
+	//stage reads done alongside its input, the way orDone does. Render it with dot -Tsvg.
 
 	//The context Package
 	//As we’ve seen, in concurrent programs it’s often necessary to preempt operations because of timeouts,
@@ -1245,6 +1308,22 @@ func main() {
 	//private to the process package, the response package has no way to retrieve this data!
 	//This coerces the architecture into creating packages centered around data types that are imported
 	//from multiple locations. This certainly isn’t a bad thing, but it’s something to be aware of.
+	//With generics we can go one step further. ctxutil.Key[T] is a typed key: the key itself is the unexported
+	//identity, it knows the type of its value, and Get hands back (value, ok) instead of panicking on a bad
+	//assertion. Because the key is a value rather than a type, it can live in whichever package both sides
+	//already import. See userIDKey and authTokenKey below.
+	//When printGreeting3 fails with context.Canceled, ctx.Err() can't tell us who did it. Deriving contexts through
+	//ctxutil records that for us:
+	//ctx, cancel := ctxutil.WithTimeout(ctx, "genGreeting", 1*time.Second) //Instead of context.WithTimeout.
+	//defer cancel(nil)
+	//...
+	//if cause := ctxutil.CauseOf(ctx); cause != nil {
+	//	log.Printf("canceled by %v at:\n%s", cause, cause.Stack()) //The name and error of whoever canceled, and where they did it.
+	//}
+	//A ctxutil.Registry does the same and can also Dump the tree of contexts still alive, with deadlines and causes.
+	//genGreeting3 only stays leak-free because of its defer cancel(). Code that takes a ctxutil.Factory instead of
+	//calling context.WithTimeout directly can be handed ctxutil.NewLeakChecker(t) in tests, which fails the test
+	//for every CancelFunc nobody called and shows where that context was created.
 
 }
 //func printGreeting(done <-chan interface{}) error {
@@ -1374,6 +1453,25 @@ func genGreeting3(ctx context.Context) (string, error) {
 	return "", fmt.Errorf("unsupported locale")
 }
 
+func genGreeting4(ctx context.Context) (string, error) { //Rather than guess at a 1-second slice of the caller's deadline, we ask a budget for locale's fair share, keeping a little back to clean up.
+	budget := ctxutil.NewBudget(ctx, 10*time.Millisecond,
+		ctxutil.Step{Name: "locale", Weight: 1, Min: 1 * time.Minute}, //This is the same minute locale3 insists on; the budget just tells us before we start rather than after.
+	)
+	localeCtx, cancel, err := budget.Start("locale")
+	defer cancel()
+	if err != nil {
+		return "", err
+	}
+
+	switch locale, err := locale3(localeCtx); {
+	case err != nil:
+		return "", err
+	case locale == "EN/US":
+		return "hello", nil
+	}
+	return "", fmt.Errorf("unsupported locale")
+}
+
 func genFarewell3(ctx context.Context) (string, error) {
 	switch locale, err := locale3(ctx); {
 	case err != nil:
@@ -1412,32 +1510,35 @@ func HandleResponse(ctx context.Context) {
 	)
 }
 
-type ctxKey int
-
-const (
-	ctxUserID ctxKey = iota
-	ctxAuthToken
+var (
+	userIDKey    = ctxutil.NewKey[string]("userID") //Each typed key is its own identity and carries its value's type with it, so there's no ctxKey enum to extend and no assertion to get wrong.
+	authTokenKey = ctxutil.NewKey[string]("authToken")
 )
 
-func UserID(c context.Context) string {
-	return c.Value(ctxUserID).(string)
+func UserID(c context.Context) (string, bool) {
+	return userIDKey.Get(c)
 }
 
-func AuthToken(c context.Context) string {
-	return c.Value(ctxAuthToken).(string)
+func AuthToken(c context.Context) (string, bool) {
+	return authTokenKey.Get(c)
 }
 
 func ProcessRequest1(userID, authToken string) {
-	ctx := context.WithValue(context.Background(), ctxUserID, userID)
-	ctx = context.WithValue(ctx, ctxAuthToken, authToken)
+	ctx := userIDKey.With(context.Background(), userID)
+	ctx = authTokenKey.With(ctx, authToken)
 	HandleResponse1(ctx)
 }
 
 func HandleResponse1(ctx context.Context) {
+	userID, ok := UserID(ctx)
+	if !ok { //A missing value is now something we can check for rather than a panic.
+		fmt.Println("cannot handle response: no user ID in context")
+		return
+	}
+	authToken, _ := AuthToken(ctx)
 	fmt.Printf(
 		"handling response for %v (auth: %v)",
-		UserID(ctx),
-		AuthToken(ctx),
+		userID,
+		authToken,
 	)
 }
-
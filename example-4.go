@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"golang.org/x/time/rate"
-	"log"
+	"io"
+	"net"
 	"os"
 	"os/exec"
-	"runtime/debug"
+	"runtime"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -90,6 +97,25 @@ func main() {
 	//	}
 	//	handleError(1, err, msg) // On this line we bind the log and error message together with an ID of 1. We could easily make this increase monotonically, or use a GUID to ensure a unique ID.
 	//}
+	//Because MyError, LowLevelErr and IntermediateErr implement Unwrap, the type assertion above can also be written
+	//as errors.As(err, &IntermediateErr{}), which keeps working if someone wraps the error again with %w. The zero
+	//values work as sentinels too: errors.Is(err, LowLevelErr{}) reports whether err crossed the low-level boundary,
+	//and crossedBoundaries(err) lists every boundary in the chain.
+	//handleError now makes the well-formed-or-bug decision itself, so the call site shrinks to:
+	//err := runJob("1")
+	//if err != nil {
+	//	handleError(ctx, err) // classifyError walks the chain; well-formed errors show their Message, bugs show a generic message pointing at the log ID.
+	//}
+	//The log side is one JSON object per error, written by an errorReporter: the log ID, the message, every link
+	//of the chain with its stack frames and Misc map, and any context values we asked for, e.g.
+	//defaultErrorReporter.CaptureContextValue("userID", ctxwire.UserIDKey). The reporter hands out the log ID itself, so
+	//there is no global log prefix for concurrent callers to trample. errClassCount(errClassBug) gives us a
+	//number to alert on.
+	//When the error has to cross a process boundary, e.g. a daemon answering a client over TCP, marshalError
+	//turns the whole chain into versioned JSON and unmarshalError rebuilds it on the other side. Registered
+	//kinds come back as their real types, so errors.Is(err, IntermediateErr{}) still works for the client, and
+	//every decoded MyError carries Misc["origin"] and a Misc["remoteStack"] summary.
+	// run -> go test errors_test.go example-4.go
 
 	//When we run this, we get a log message that contains:
 	//[logID: 1]: 22:11:04 main.IntermediateErr{error:main.MyError
@@ -829,15 +855,52 @@ func or(done chan interface{}, done2 <-chan interface{}) <-chan interface{} {
 type MyError struct {
 	Inner      error
 	Message    string
-	StackTrace string
+	StackTrace stackTrace
 	Misc       map[string]interface{}
 }
 
+var maxStackDepth = 32 //How many frames wrapError records. Deep enough for most call chains, and it bounds the cost of each wrap.
+
+type stackTrace []uintptr //Raw program counters are cheap to record; we only turn them into function names and lines when someone prints the stack.
+
+func callers(skip int) stackTrace {
+	pcs := make([]uintptr, maxStackDepth)
+	n := runtime.Callers(skip+2, pcs) //Skip runtime.Callers and callers itself, plus whatever wrapper frames the caller asks us to drop.
+	return stackTrace(pcs[:n])
+}
+
+func (st stackTrace) Frames() []runtime.Frame {
+	var frames []runtime.Frame
+	if len(st) == 0 {
+		return frames
+	}
+	iter := runtime.CallersFrames(st)
+	for {
+		frame, more := iter.Next()
+		frames = append(frames, frame)
+		if !more {
+			return frames
+		}
+	}
+}
+
+func (st stackTrace) String() string {
+	var b strings.Builder
+	for _, frame := range st.Frames() {
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+	}
+	return b.String()
+}
+
 func wrapError(err error, messagef string, msgArgs ...interface{}) MyError {
+	message := fmt.Errorf(messagef, msgArgs...)
+	if err == nil {
+		err = errors.Unwrap(message) // If the caller used %w rather than passing err, we still keep hold of what was wrapped.
+	}
 	return MyError{
 		Inner:      err, // Here we store the error we’re wrapping. We always want to be able to get back to the lowest-level error in case we need to investigate what happened.
-		Message:    fmt.Sprintf(messagef, msgArgs...),
-		StackTrace: string(debug.Stack()),        //This line of code takes note of the stack trace when the error was created. A more sophisticated error type might elide the stack-frame from wrapError.
+		Message:    message.Error(),
+		StackTrace: callers(1),                   //This line of code takes note of the stack trace when the error was created. We skip wrapError's own frame so the trace starts where the error was wrapped.
 		Misc:       make(map[string]interface{}), //Here we create a catch-all for storing miscellaneous information. This is where we might store the concurrent ID, a hash of the stack trace, or other contextual information that might help in diagnosing the error.
 	}
 }
@@ -846,14 +909,70 @@ func (err MyError) Error() string {
 	return err.Message
 }
 
+func (err MyError) Unwrap() error {
+	return err.Inner // This lets errors.Is and errors.As walk past our error to whatever it wraps, all the way down to the os.Stat failure.
+}
+
+type boundaryErr interface { //Every module's error type reports the boundary it was wrapped at, so any error in a chain can tell us where it crossed.
+	error
+	Boundary() string
+}
+
+func crossedBoundaries(err error) []string {
+	var boundaries []string
+	for ; err != nil; err = errors.Unwrap(err) {
+		if b, ok := err.(boundaryErr); ok {
+			boundaries = append(boundaries, b.Boundary())
+		}
+	}
+	return boundaries // Outermost boundary first, e.g. [intermediate lowlevel].
+}
+
+// isTransient reports whether err is worth trying again. The first module in the chain with an opinion
+// decides: an IntermediateErr is transient only if it was tagged Misc["transient"], a MyError tagged either
+// way is believed, and an untagged net.Error is transient if it timed out. The job runner and retry both
+// go by this, so they never disagree about the same error.
+func isTransient(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		switch e := err.(type) {
+		case IntermediateErr: //The intermediate module has already decided what its errors mean: unless it tagged this one transient, it's a configuration problem and retrying won't fix it.
+			myErr, _ := e.error.(MyError)
+			transient, _ := myErr.Misc["transient"].(bool)
+			return transient
+		case MyError:
+			if transient, ok := e.Misc["transient"].(bool); ok {
+				return transient
+			}
+		case net.Error:
+			if e.Timeout() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type LowLevelErr struct {
 	error
 }
 
+func (err LowLevelErr) Unwrap() error {
+	return err.error
+}
+
+func (err LowLevelErr) Is(target error) bool { //A zero LowLevelErr{} works as a sentinel: errors.Is(err, LowLevelErr{}) asks whether err crossed the low-level boundary.
+	_, ok := target.(LowLevelErr)
+	return ok
+}
+
+func (err LowLevelErr) Boundary() string {
+	return "lowlevel"
+}
+
 func isGloballyExec(path string) (bool, error) {
 	info, err := os.Stat(path)
 	if err != nil {
-		return false, LowLevelErr{wrapError(err, err.Error())} //Here we wrap the raw error from calling os.Stat with a customized error. In this case we are OK with the message coming out of this error, and so we won’t mask it.
+		return false, LowLevelErr{wrapError(err, "%v", err)} //Here we wrap the raw error from calling os.Stat with a customized error. In this case we are OK with the message coming out of this error, and so we won’t mask it.
 	}
 	return info.Mode().Perm()&0100 == 0100, nil
 }
@@ -862,6 +981,19 @@ type IntermediateErr struct {
 	error
 }
 
+func (err IntermediateErr) Unwrap() error {
+	return err.error
+}
+
+func (err IntermediateErr) Is(target error) bool {
+	_, ok := target.(IntermediateErr)
+	return ok
+}
+
+func (err IntermediateErr) Boundary() string {
+	return "intermediate"
+}
+
 func runJob(id string) error {
 	const jobBinPath = "/bad/job/binary"
 	isExecutable, err := isGloballyExec(jobBinPath)
@@ -879,13 +1011,298 @@ func runJob(id string) error {
 		)
 	}
 
-	return exec.Command(jobBinPath, "--id="+id).Run()
+	return startJob(id, jobBinPath)
+}
+
+// startJob runs the job's binary. Builds that include jobrunner.go swap in its pooled runner, which takes care of
+// timeouts, output capture, exit codes and retries; on its own this file still builds everywhere.
+var startJob = func(id, path string) error {
+	return exec.Command(path, "--id="+id).Run()
+}
+
+type errClass int
+
+const (
+	errClassWellFormed errClass = iota
+	errClassBug
+	numErrClasses
+)
+
+func (c errClass) String() string {
+	switch c {
+	case errClassWellFormed:
+		return "well-formed"
+	case errClassBug:
+		return "bug"
+	}
+	return fmt.Sprintf("errClass(%d)", int(c))
+}
+
+var errClassCounts [numErrClasses]int64
+
+func errClassCount(class errClass) int64 {
+	return atomic.LoadInt64(&errClassCounts[class])
+}
+
+func classifyError(err error) errClass {
+	if errors.As(err, new(IntermediateErr)) { //At the top of our program we only call into the intermediate module, so its error type anywhere in the chain means someone crafted this error on purpose.
+		return errClassWellFormed
+	}
+	return errClassBug
+}
+
+type errorReport struct {
+	LogID   string                 `json:"logID"`
+	Time    time.Time              `json:"time"`
+	Class   string                 `json:"class"`
+	Message string                 `json:"message"`
+	Chain   []errorReportLink      `json:"chain"`
+	Context map[string]interface{} `json:"context,omitempty"`
+}
+
+type errorReportLink struct {
+	Type     string                 `json:"type"`
+	Message  string                 `json:"message"`
+	Boundary string                 `json:"boundary,omitempty"`
+	Stack    []errorReportFrame     `json:"stack,omitempty"`
+	Misc     map[string]interface{} `json:"misc,omitempty"`
+}
+
+type errorReportFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+func newErrorReporter(out io.Writer) *errorReporter {
+	return &errorReporter{
+		out:         out,
+		idPrefix:    strconv.FormatInt(time.Now().UnixNano(), 36), //Each reporter gets its own prefix so IDs from different runs of the program don't collide in the logs.
+		contextKeys: make(map[string]interface{}),
+	}
+}
+
+type errorReporter struct {
+	mu          sync.Mutex
+	out         io.Writer
+	idPrefix    string
+	seq         uint64
+	contextKeys map[string]interface{}
+}
+
+func (r *errorReporter) CaptureContextValue(name string, key interface{}) { //Here we register a context key, e.g. ctxwire.UserIDKey, whose value should be copied into every report under name.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.contextKeys[name] = key
+}
+
+func (r *errorReporter) Report(ctx context.Context, err error) (logID string, message string) {
+	class := classifyError(err)
+	atomic.AddInt64(&errClassCounts[class], 1)
+
+	logID = fmt.Sprintf("%s-%d", r.idPrefix, atomic.AddUint64(&r.seq, 1)) //The ID is handed back to the caller rather than stashed in a global log prefix, so concurrent reports can't steal each other's IDs.
+	message = fmt.Sprintf("There was an unexpected issue; please report this as a bug and quote log ID %v.", logID)
+	if class == errClassWellFormed {
+		var wellFormed IntermediateErr
+		errors.As(err, &wellFormed)
+		message = wellFormed.Error()
+	}
+
+	report := errorReport{
+		LogID:   logID,
+		Time:    time.Now().UTC(),
+		Class:   class.String(),
+		Message: message,
+		Chain:   reportChain(err),
+		Context: r.contextValues(ctx),
+	}
+	line, marshalErr := json.Marshal(report)
+	if marshalErr != nil {
+		line, _ = json.Marshal(errorReport{LogID: logID, Time: report.Time, Class: report.Class, Message: marshalErr.Error()})
+	}
+
+	r.mu.Lock() //We hold the lock only for the write so that each report lands on its own line, whole.
+	defer r.mu.Unlock()
+	_, _ = r.out.Write(append(line, '\n'))
+	return logID, message
+}
+
+func (r *errorReporter) contextValues(ctx context.Context) map[string]interface{} {
+	if ctx == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var values map[string]interface{}
+	for name, key := range r.contextKeys {
+		if v := ctx.Value(key); v != nil {
+			if values == nil {
+				values = make(map[string]interface{})
+			}
+			values[name] = jsonSafe(v)
+		}
+	}
+	return values
+}
+
+func reportChain(err error) []errorReportLink {
+	var chain []errorReportLink
+	for ; err != nil; err = errors.Unwrap(err) {
+		link := errorReportLink{Type: fmt.Sprintf("%T", err), Message: err.Error()}
+		if b, ok := err.(boundaryErr); ok {
+			link.Boundary = b.Boundary()
+		}
+		if myErr, ok := err.(MyError); ok {
+			for _, frame := range myErr.StackTrace.Frames() {
+				link.Stack = append(link.Stack, errorReportFrame{Function: frame.Function, File: frame.File, Line: frame.Line})
+			}
+			for k, v := range myErr.Misc {
+				if link.Misc == nil {
+					link.Misc = make(map[string]interface{})
+				}
+				link.Misc[k] = jsonSafe(v)
+			}
+		}
+		chain = append(chain, link)
+	}
+	return chain
+}
+
+func jsonSafe(v interface{}) interface{} {
+	if _, err := json.Marshal(v); err != nil { //Misc and context values can hold anything; whatever JSON can't encode we fall back to printing.
+		return fmt.Sprintf("%v", v)
+	}
+	return v
+}
+
+var defaultErrorReporter = newErrorReporter(os.Stderr)
+
+func handleError(ctx context.Context, err error) {
+	logID, message := defaultErrorReporter.Report(ctx, err) //Here we log out the full error in case someone needs to dig into what happened, and get back the ID that ties the log to what the user sees.
+	fmt.Printf("[%v] %v", logID, message)
+}
+
+const wireErrorVersion = 1 //Bump this whenever the wire format changes in a way older clients can't read.
+
+const wireStackFrames = 8 //Only a summary of the stack travels; the full trace stays in the remote process's own error report.
+
+type wireEnvelope struct {
+	Version int        `json:"v"`
+	Error   *wireError `json:"error"`
+}
+
+type wireError struct {
+	Kind    string                 `json:"kind,omitempty"` //A registered kind such as "intermediate", or empty for errors we can only carry as text.
+	GoType  string                 `json:"goType"`
+	Message string                 `json:"message"`
+	Stack   []string               `json:"stack,omitempty"`
+	Misc    map[string]interface{} `json:"misc,omitempty"`
+	Inner   *wireError             `json:"inner,omitempty"`
+}
+
+const wireKindMyError = "myerror"
+
+var wireErrorKinds = struct {
+	sync.RWMutex
+	wrap map[string]func(inner error) error
+}{
+	wrap: map[string]func(inner error) error{
+		"lowlevel":     func(inner error) error { return LowLevelErr{inner} },
+		"intermediate": func(inner error) error { return IntermediateErr{inner} },
+	},
+}
+
+func registerWireErrorKind(kind string, wrap func(inner error) error) { //Here a module registers its boundary error type, keyed by the name its Boundary method returns, so the other side can rebuild it.
+	wireErrorKinds.Lock()
+	defer wireErrorKinds.Unlock()
+	wireErrorKinds.wrap[kind] = wrap
+}
+
+func marshalError(err error) ([]byte, error) {
+	return json.Marshal(wireEnvelope{Version: wireErrorVersion, Error: toWireError(err)})
+}
+
+func toWireError(err error) *wireError {
+	if err == nil {
+		return nil
+	}
+	w := &wireError{GoType: fmt.Sprintf("%T", err), Message: err.Error()}
+	switch e := err.(type) {
+	case MyError:
+		w.Kind = wireKindMyError
+		for i, frame := range e.StackTrace.Frames() {
+			if i == wireStackFrames {
+				break
+			}
+			w.Stack = append(w.Stack, fmt.Sprintf("%s %s:%d", frame.Function, frame.File, frame.Line))
+		}
+		for k, v := range e.Misc {
+			if w.Misc == nil {
+				w.Misc = make(map[string]interface{})
+			}
+			w.Misc[k] = jsonSafe(v)
+		}
+	case boundaryErr:
+		wireErrorKinds.RLock()
+		if _, ok := wireErrorKinds.wrap[e.Boundary()]; ok {
+			w.Kind = e.Boundary()
+		}
+		wireErrorKinds.RUnlock()
+	}
+	w.Inner = toWireError(errors.Unwrap(err))
+	return w
+}
+
+func unmarshalError(data []byte, origin string) (error, error) {
+	var envelope wireEnvelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Version != wireErrorVersion {
+		return nil, fmt.Errorf("unsupported wire error version %d", envelope.Version)
+	}
+	return envelope.Error.toError(origin), nil
+}
+
+func (w *wireError) toError(origin string) error {
+	if w == nil {
+		return nil
+	}
+	inner := w.Inner.toError(origin)
+
+	if w.Kind == wireKindMyError {
+		misc := make(map[string]interface{}, len(w.Misc)+2)
+		for k, v := range w.Misc {
+			misc[k] = v
+		}
+		misc["origin"] = origin //Here we note where the error came from, so a client can tell an IntermediateErr raised by the remote job runner from one of its own.
+		if len(w.Stack) > 0 {
+			misc["remoteStack"] = w.Stack
+		}
+		return MyError{Inner: inner, Message: w.Message, Misc: misc} //StackTrace stays empty: those program counters only mean something inside the remote process.
+	}
+
+	wireErrorKinds.RLock()
+	wrap, ok := wireErrorKinds.wrap[w.Kind]
+	wireErrorKinds.RUnlock()
+	if ok {
+		return wrap(inner)
+	}
+	return remoteError{GoType: w.GoType, Message: w.Message, Inner: inner}
+}
+
+type remoteError struct { //This stands in for any error type we don't know how to rebuild. It keeps the message and the remote type name for the logs.
+	GoType  string
+	Message string
+	Inner   error
+}
+
+func (err remoteError) Error() string {
+	return err.Message
 }
 
-func handleError(key int, err error, message string) {
-	log.SetPrefix(fmt.Sprintf("[logID: %v]: ", key))
-	log.Printf("%#v", err) //Here we log out the full error in case someone needs to dig into what happened.
-	fmt.Printf("[%v] %v", key, message)
+func (err remoteError) Unwrap() error {
+	return err.Inner
 }
 
 func DoWork(done <-chan interface{}, nums ...int) (<-chan interface{}, <-chan int) {
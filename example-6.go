@@ -117,6 +117,15 @@ func main() {
 	//These built-in profiles can really help you profile and diagnose issues with your program, but of course
 	//you can write custom profiles tailored to help you monitor your programs:
 	//prof := newProfIfNotDef("my_package_namespace")
+	//The pipeline package uses exactly this to show where a pipeline's goroutines are stuck. EnableProfiles gives each
+	//stage its own profile, and a worker is in it, with its stack, for as long as it is blocked on receive or send:
+	//p := pipeline.New()
+	//p.EnableProfiles("pipeline", newProfIfNotDef)
+	//...
+	//pprof.Lookup("pipeline.toString").WriteTo(os.Stdout, 1)
+	//The counts say how many workers are waiting and on which side. p.Metrics() has the rest: items in and out, how
+	//long each item took, how long the workers spent blocked on either side, and how full each buffer has been. The
+	//stage that is never blocked on receive, while the one before it is blocked on send, is the bottleneck.
 }
 
 func newProfIfNotDef(name string) *pprof.Profile {
@@ -1,5 +1,5 @@
 module github.com/yugant007/advanced-golang-concurrency
 
-go 1.14
+go 1.21
 
-require golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba // indirect
+require golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
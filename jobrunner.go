@@ -0,0 +1,180 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// run -> go test jobrunner_test.go example-4.go jobrunner.go
+
+var defaultJobRunner = newJobRunner(jobRunnerConfig{
+	Timeout:            5 * time.Minute,
+	MaxAttempts:        3,
+	RetryDelay:         time.Second,
+	TransientExitCodes: []int{75}, //EX_TEMPFAIL: the job is telling us to try again later.
+	Concurrency:        4,
+})
+
+func init() {
+	startJob = func(id, path string) error { //Here we put runJob in example-4.go behind the runner whenever this file is part of the build.
+		_, err := defaultJobRunner.Run(context.Background(), id, path)
+		return err
+	}
+}
+
+type jobRunnerConfig struct {
+	Timeout            time.Duration // How long a single attempt may run before its whole process group is killed.
+	MaxOutput          int           // How many bytes of stdout and of stderr we keep per attempt.
+	MaxAttempts        int
+	RetryDelay         time.Duration
+	TransientExitCodes []int // Exit codes worth retrying, e.g. 75 (EX_TEMPFAIL).
+	Concurrency        int   // How many jobs may run at once.
+}
+
+func newJobRunner(config jobRunnerConfig) *jobRunner {
+	if config.Timeout <= 0 {
+		config.Timeout = time.Minute
+	}
+	if config.MaxOutput <= 0 {
+		config.MaxOutput = 64 << 10
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 1
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+	transient := make(map[int]bool, len(config.TransientExitCodes))
+	for _, code := range config.TransientExitCodes {
+		transient[code] = true
+	}
+	return &jobRunner{
+		config:    config,
+		transient: transient,
+		pool:      make(chan struct{}, config.Concurrency),
+	}
+}
+
+type jobRunner struct {
+	config    jobRunnerConfig
+	transient map[int]bool
+	pool      chan struct{}
+}
+
+type jobOutput struct {
+	Stdout, Stderr []byte
+	Truncated      bool // Whether either stream went over MaxOutput.
+	ExitCode       int
+	Attempts       int
+}
+
+func (r *jobRunner) Run(ctx context.Context, id, path string, args ...string) (jobOutput, error) {
+	select {
+	case <-ctx.Done(): //Here we wait for a slot in the pool, but we won't wait past the caller's deadline.
+		return jobOutput{}, IntermediateErr{wrapError(ctx.Err(), "cannot run job %q: gave up waiting for a free runner", id)}
+	case r.pool <- struct{}{}:
+	}
+	defer func() { <-r.pool }()
+
+	var out jobOutput
+	var err error
+	for attempt := 1; ; attempt++ {
+		out, err = r.runOnce(ctx, id, path, args)
+		out.Attempts = attempt
+		if err == nil || !isTransient(err) || attempt == r.config.MaxAttempts {
+			return out, err
+		}
+		select {
+		case <-ctx.Done():
+			return out, err
+		case <-time.After(r.config.RetryDelay):
+		}
+	}
+}
+
+func (r *jobRunner) runOnce(ctx context.Context, id, path string, args []string) (jobOutput, error) {
+	attemptCtx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	stdout := &cappedBuffer{max: r.config.MaxOutput}
+	stderr := &cappedBuffer{max: r.config.MaxOutput}
+	cmd := exec.Command(path, append([]string{"--id=" + id}, args...)...)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true} //Here we put the job in its own process group so that a timeout takes down anything it spawned, not just the job itself.
+
+	if err := cmd.Start(); err != nil {
+		return jobOutput{ExitCode: -1}, IntermediateErr{wrapError(
+			LowLevelErr{wrapError(err, "%v", err)},
+			"cannot run job %q: could not start process",
+			id,
+		)}
+	}
+
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- cmd.Wait() }()
+
+	var err error
+	select {
+	case err = <-waitErr:
+	case <-attemptCtx.Done():
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) //A negative pid signals the whole group.
+		err = <-waitErr
+	}
+
+	out := jobOutput{
+		Stdout:    stdout.buf,
+		Stderr:    stderr.buf,
+		Truncated: stdout.dropped > 0 || stderr.dropped > 0,
+		ExitCode:  cmd.ProcessState.ExitCode(),
+	}
+	if err == nil {
+		return out, nil
+	}
+	return out, r.classify(ctx, attemptCtx, id, err, out)
+}
+
+func (r *jobRunner) classify(ctx, attemptCtx context.Context, id string, err error, out jobOutput) error {
+	low := wrapError(err, "%v", err)
+	low.Misc["exitCode"] = out.ExitCode
+	low.Misc["stderr"] = string(out.Stderr)
+
+	switch {
+	case ctx.Err() != nil: //The caller gave up on us; that's their decision, so there's nothing to retry.
+		low.Inner = ctx.Err()
+		return IntermediateErr{wrapError(LowLevelErr{low}, "job %q was canceled", id)}
+	case attemptCtx.Err() != nil:
+		low.Inner = attemptCtx.Err()
+		intermediate := wrapError(LowLevelErr{low}, "job %q timed out after %v", id, r.config.Timeout)
+		intermediate.Misc["transient"] = true
+		return IntermediateErr{intermediate}
+	}
+
+	intermediate := wrapError(LowLevelErr{low}, "job %q failed with exit status %d", id, out.ExitCode)
+	intermediate.Misc["transient"] = r.transient[out.ExitCode]
+	return IntermediateErr{intermediate}
+}
+
+type cappedBuffer struct { //We keep the first max bytes and quietly count the rest, so a chatty job can't exhaust our memory or block on a full pipe.
+	buf     []byte
+	max     int
+	dropped int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - len(b.buf); room > 0 {
+		if len(p) <= room {
+			b.buf = append(b.buf, p...)
+			return len(p), nil
+		}
+		b.buf = append(b.buf, p[:room]...)
+		b.dropped += len(p) - room
+		return len(p), nil
+	}
+	b.dropped += len(p)
+	return len(p), nil
+}
@@ -0,0 +1,149 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeJobScript(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "job.sh")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestJobRunner_CapturesOutput(t *testing.T) {
+	path := writeJobScript(t, `echo "running $1"; echo "warming up" >&2`)
+	runner := newJobRunner(jobRunnerConfig{})
+
+	out, err := runner.Run(context.Background(), "7", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(out.Stdout) != "running --id=7\n" || string(out.Stderr) != "warming up\n" {
+		t.Errorf("unexpected output %q / %q", out.Stdout, out.Stderr)
+	}
+	if out.ExitCode != 0 || out.Attempts != 1 || out.Truncated {
+		t.Errorf("unexpected result %+v", out)
+	}
+}
+
+func TestJobRunner_CapsOutput(t *testing.T) {
+	path := writeJobScript(t, `i=0; while [ $i -lt 100 ]; do echo "0123456789"; i=$((i+1)); done`)
+	runner := newJobRunner(jobRunnerConfig{MaxOutput: 16})
+
+	out, err := runner.Run(context.Background(), "1", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(out.Stdout) != 16 || !out.Truncated {
+		t.Errorf("expected 16 bytes and Truncated, got %d bytes, %+v", len(out.Stdout), out.Truncated)
+	}
+}
+
+func TestJobRunner_ClassifiesExitCode(t *testing.T) {
+	path := writeJobScript(t, `echo "bad config" >&2; exit 3`)
+	runner := newJobRunner(jobRunnerConfig{MaxAttempts: 3, TransientExitCodes: []int{75}})
+
+	out, err := runner.Run(context.Background(), "1", path)
+	if out.ExitCode != 3 || out.Attempts != 1 {
+		t.Errorf("expected a single attempt with exit code 3, got %+v", out)
+	}
+	if !errors.Is(err, IntermediateErr{}) || !errors.Is(err, LowLevelErr{}) {
+		t.Fatalf("expected intermediate and low-level boundaries, got %#v", err)
+	}
+	if err.Error() != `job "1" failed with exit status 3` {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+	if isTransient(err) {
+		t.Errorf("expected exit code 3 not to be transient")
+	}
+
+	var low LowLevelErr
+	var myErr MyError
+	errors.As(err, &low)
+	errors.As(low, &myErr)
+	if myErr.Misc["exitCode"] != 3 || myErr.Misc["stderr"] != "bad config\n" {
+		t.Errorf("unexpected low-level details %v", myErr.Misc)
+	}
+}
+
+func TestJobRunner_RetriesTransientExitCode(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "attempts")
+	path := writeJobScript(t, `echo x >> `+counter+`; [ $(wc -l < `+counter+`) -ge 3 ] || exit 75`)
+	runner := newJobRunner(jobRunnerConfig{MaxAttempts: 5, RetryDelay: time.Millisecond, TransientExitCodes: []int{75}})
+
+	out, err := runner.Run(context.Background(), "1", path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.Attempts != 3 {
+		t.Errorf("expected 3 attempts, but received %v", out.Attempts)
+	}
+}
+
+func TestJobRunner_TimeoutKillsProcessGroup(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "child-survived")
+	path := writeJobScript(t, `(sleep 0.5; touch `+marker+`) & wait`)
+	runner := newJobRunner(jobRunnerConfig{Timeout: 100 * time.Millisecond})
+
+	start := time.Now()
+	_, err := runner.Run(context.Background(), "1", path)
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("expected the job to be killed promptly, took %v", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !isTransient(err) {
+		t.Errorf("expected a transient deadline error, got %#v", err)
+	}
+
+	time.Sleep(time.Second)
+	if _, err := os.Stat(marker); err == nil {
+		t.Errorf("expected the job's child to be killed with its process group")
+	}
+}
+
+func TestJobRunner_CallerCancellationIsNotRetried(t *testing.T) {
+	path := writeJobScript(t, `sleep 30`)
+	runner := newJobRunner(jobRunnerConfig{MaxAttempts: 3})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	out, err := runner.Run(ctx, "1", path)
+	if out.Attempts != 1 || isTransient(err) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected one non-transient attempt, got %+v, %v", out, err)
+	}
+}
+
+func TestJobRunner_BoundsConcurrentJobs(t *testing.T) {
+	path := writeJobScript(t, `sleep 1`)
+	runner := newJobRunner(jobRunnerConfig{Concurrency: 1})
+
+	go runner.Run(context.Background(), "1", path)
+	time.Sleep(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := runner.Run(ctx, "2", path)
+	if err == nil || !strings.Contains(err.Error(), "gave up waiting for a free runner") {
+		t.Errorf("expected the second job to wait for the pool and give up, got %v", err)
+	}
+}
+
+func TestJobRunner_MissingBinary(t *testing.T) {
+	runner := newJobRunner(jobRunnerConfig{})
+	_, err := runner.Run(context.Background(), "1", filepath.Join(os.TempDir(), "does-not-exist"))
+	if !errors.Is(err, os.ErrNotExist) || classifyError(err) != errClassWellFormed {
+		t.Errorf("expected a well-formed not-exist error, got %#v", err)
+	}
+}
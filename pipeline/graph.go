@@ -0,0 +1,132 @@
+package pipeline
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"runtime/pprof"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// stage is what the pipeline knows about one of its stages, for metrics and for drawing the graph.
+type stage struct {
+	id      int
+	name    string
+	kind    string
+	workers int
+	buffer  int
+	errs    chan error
+	outputs []*channel
+	profile atomic.Pointer[pprof.Profile]
+	metrics stageMetrics
+}
+
+// channel is what the pipeline knows about one of the channels between stages, for drawing the graph.
+type channel struct {
+	to      []*stage
+	length  func() int
+	sent    int64
+	created time.Time
+
+	lastSent int64 // Guarded by the pipeline's mu; where the last DOT export left off.
+	lastAt   time.Time
+}
+
+func (p *Pipeline) add(kind, name string, opts Options, inputs ...*channel) *stage {
+	s := &stage{
+		name:    name,
+		kind:    kind,
+		workers: opts.Workers,
+		buffer:  opts.Buffer,
+		errs:    make(chan error, opts.Buffer),
+	}
+	if s.workers < 1 {
+		s.workers = 1
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.lookup != nil {
+		s.profile.Store(p.lookup(p.profilePrefix + "." + name))
+	}
+	for _, in := range inputs {
+		in.to = append(in.to, s)
+	}
+	s.id = len(p.stages)
+	p.stages = append(p.stages, s)
+	return s
+}
+
+func (p *Pipeline) newChannel(from *stage, length func() int) *channel {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := &channel{length: length, created: time.Now()}
+	from.outputs = append(from.outputs, c)
+	return c
+}
+
+func (s *stage) queued() int {
+	n := 0
+	for _, out := range s.outputs {
+		n += out.length()
+	}
+	return n
+}
+
+// WriteDOT writes the pipeline as a Graphviz graph: a node per stage, an edge per channel between stages
+// labeled with its buffer and throughput, and a dotted edge from done to every stage, since each of them
+// reads done alongside its input the way orDone does. Throughput is measured since the previous WriteDOT,
+// or since the channel was made, so calling it on a timer gives live rates. Render it with
+// dot -Tsvg pipeline.dot > pipeline.svg.
+func (p *Pipeline) WriteDOT(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "digraph pipeline {")
+	fmt.Fprintln(b, "\trankdir=LR;")
+	fmt.Fprintln(b, "\tnode [shape=box];")
+	fmt.Fprintln(b, "\tdone [shape=octagon];")
+	for _, s := range p.stages {
+		label := s.name + "\n" + s.kind
+		if s.workers > 1 {
+			label += fmt.Sprintf(" x%d", s.workers)
+		}
+		fmt.Fprintf(b, "\ts%d [label=%s];\n", s.id, dotQuote(label))
+		fmt.Fprintf(b, "\tdone -> s%d [style=dotted, arrowhead=none];\n", s.id)
+	}
+	for _, s := range p.stages {
+		for i, c := range s.outputs {
+			sent := atomic.LoadInt64(&c.sent)
+			since, before := c.created, int64(0)
+			if !c.lastAt.IsZero() {
+				since, before = c.lastAt, c.lastSent
+			}
+			c.lastAt, c.lastSent = now, sent
+			rate := 0.0
+			if elapsed := now.Sub(since).Seconds(); elapsed > 0 {
+				rate = float64(sent-before) / elapsed
+			}
+			label := dotQuote(fmt.Sprintf("%d/%d buffered\n%d sent, %.1f/s", c.length(), s.buffer, sent, rate))
+
+			if len(c.to) == 0 { //Nobody in the pipeline reads this one, so it is an end the caller reads.
+				fmt.Fprintf(b, "\ts%d_out%d [shape=point];\n", s.id, i)
+				fmt.Fprintf(b, "\ts%d -> s%d_out%d [label=%s];\n", s.id, s.id, i, label)
+			}
+			for _, to := range c.to {
+				fmt.Fprintf(b, "\ts%d -> s%d [label=%s];\n", s.id, to.id, label)
+			}
+		}
+	}
+	fmt.Fprintln(b, "}")
+	return b.Flush()
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
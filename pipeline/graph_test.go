@@ -0,0 +1,112 @@
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func identity(v int) (int, error) { return v, nil }
+
+func TestTee(t *testing.T) {
+	p := New()
+	checked := Map(count(p, 4), "check", Options{}, rejectOdd)
+	execute, audit := Tee(checked, "tee", Options{})
+
+	var executed, audited []Result[int]
+	for r := range audit.Out() { //Reading the second output first must not deadlock.
+		audited = append(audited, r)
+		executed = append(executed, <-execute.Out())
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(executed, audited) || len(executed) != 4 {
+		t.Fatalf("got %v and %v, want the same four results on both", executed, audited)
+	}
+	if !errors.Is(executed[1].Err, errOdd) {
+		t.Fatalf("got %v, want the forwarded error to be teed too", executed[1])
+	}
+}
+
+func TestMergeAndBridge(t *testing.T) {
+	p := New()
+	chans := Generate(p, "chans", Options{}, func(emit func(<-chan int) bool) error {
+		for i := 0; i < 3; i++ {
+			c := make(chan int, 2)
+			c <- i * 10
+			c <- i*10 + 1
+			close(c)
+			if !emit(c) {
+				return nil
+			}
+		}
+		return nil
+	})
+	bridged := Bridge(chans, "bridge", Options{})
+	merged := Merge("merge", Options{}, bridged, count(p, 3))
+
+	values, err := Collect(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(values)
+	if want := []int{0, 0, 1, 1, 2, 10, 11, 20, 21}; !reflect.DeepEqual(values, want) {
+		t.Fatalf("got %v, want %v", values, want)
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	p := New()
+	execute, audit := Tee(count(p, 100), "tee", Options{})
+	doubled := Map(execute, "double", Options{Workers: 2, Buffer: 8}, func(v int) (int, error) { return v * 2, nil })
+	merged := Merge("merge", Options{Buffer: 4}, doubled, Map(audit, "audit", Options{}, identity))
+	values, err := Collect(merged)
+	if err != nil || len(values) != 200 {
+		t.Fatalf("got %d values and %v", len(values), err)
+	}
+
+	var dot bytes.Buffer
+	if err := p.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	graph := dot.String()
+	for _, want := range []string{
+		"digraph pipeline {",
+		`s0 [label="count\nsource"];`,
+		`s1 [label="tee\ntee"];`,
+		`s2 [label="double\nmap x2"];`,
+		`s4 [label="merge\nmerge x2"];`,
+		"done -> s3 [style=dotted, arrowhead=none];",
+		`s0 -> s1 [label="0/0 buffered\n100 sent`,
+		`s1 -> s2 [label="0/0 buffered\n100 sent`,
+		`s1 -> s3 [label="0/0 buffered\n100 sent`,
+		`s2 -> s4 [label="0/8 buffered\n100 sent`,
+		`s3 -> s4 [label=`,
+		"s4_out0 [shape=point];",
+		`s4 -> s4_out0 [label="0/4 buffered\n200 sent`,
+	} {
+		if !strings.Contains(graph, want) {
+			t.Errorf("the graph is missing %s:\n%s", want, graph)
+		}
+	}
+	if strings.Count(graph, "->") != 11 { //Five stages with an edge from done each, and six channels.
+		t.Errorf("the graph has %d edges, want 11:\n%s", strings.Count(graph, "->"), graph)
+	}
+
+	rate := regexp.MustCompile(`s0 -> s1 \[label="0/0 buffered\\n100 sent, ([0-9.]+)/s"\]`)
+	if m := rate.FindStringSubmatch(graph); m == nil || m[1] == "0.0" {
+		t.Fatalf("want a non-zero rate on count -> tee:\n%s", graph)
+	}
+	time.Sleep(time.Millisecond)
+	dot.Reset()
+	p.WriteDOT(&dot)
+	if m := rate.FindStringSubmatch(dot.String()); m == nil || m[1] != "0.0" { //Nothing has been sent since the last export.
+		t.Fatalf("want a zero rate on count -> tee the second time:\n%s", dot.String())
+	}
+}
@@ -0,0 +1,188 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"runtime/pprof"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// histogramBounds are the upper bounds of the processing-time buckets, each four times the last.
+var histogramBounds = [...]time.Duration{
+	time.Microsecond, 4 * time.Microsecond, 16 * time.Microsecond, 64 * time.Microsecond, 256 * time.Microsecond,
+	time.Millisecond, 4 * time.Millisecond, 16 * time.Millisecond, 64 * time.Millisecond, 256 * time.Millisecond,
+	time.Second, 4 * time.Second,
+}
+
+type histogram struct {
+	counts [len(histogramBounds) + 1]int64 //The last bucket is for anything slower than the last bound.
+	sum    int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(histogramBounds) && d > histogramBounds[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// Histogram is a snapshot of how long a stage's function took.
+type Histogram struct {
+	Counts []int64 // Counts[i] is how many took at most Bounds()[i]; the extra last count is for the rest.
+	Count  int64
+	Sum    time.Duration
+}
+
+func (h Histogram) Bounds() []time.Duration {
+	return histogramBounds[:]
+}
+
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns the upper bound of the bucket holding the q-th quantile, so it overstates by at most a
+// factor of four. Anything past the last bound is reported as the last bound.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := int64(q*float64(h.Count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range h.Counts {
+		if seen += n; seen >= rank && i < len(histogramBounds) {
+			return histogramBounds[i]
+		}
+	}
+	return histogramBounds[len(histogramBounds)-1]
+}
+
+type stageMetrics struct {
+	in, out, errors int64
+	blockedReceive  int64 // Nanoseconds, summed over the stage's workers.
+	blockedSend     int64
+	queued          int64 // The output buffer's length after each send, summed, for the mean occupancy.
+	processing      histogram
+}
+
+// worker is one goroutine of a stage. It is also the key under which that goroutine appears in the
+// stage's profile while it is blocked.
+type worker struct {
+	stage *stage
+}
+
+// StageMetrics is a snapshot of one stage. As the book's Queuing section puts it, the bottleneck is the
+// stage whose workers are never blocked on receive while the stage before it is blocked on send.
+type StageMetrics struct {
+	Stage           string
+	Workers, Buffer int
+	In, Out, Errors int64
+	Processing      Histogram
+	BlockedReceive  time.Duration // Time spent waiting for input, summed over the workers.
+	BlockedSend     time.Duration // Time spent waiting for the next stage to take output, summed over the workers.
+	Queued          int           // How many results are sitting in the output buffer right now.
+	MeanQueued      float64       // How full the output buffer was, on average, right after each send.
+}
+
+// Metrics returns a snapshot of every stage, in the order they were added.
+func (p *Pipeline) Metrics() []StageMetrics {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	snapshot := make([]StageMetrics, len(p.stages))
+	for i, s := range p.stages {
+		m := &s.metrics
+		h := Histogram{Counts: make([]int64, len(m.processing.counts))}
+		for j := range h.Counts {
+			h.Counts[j] = atomic.LoadInt64(&m.processing.counts[j])
+			h.Count += h.Counts[j]
+		}
+		h.Sum = time.Duration(atomic.LoadInt64(&m.processing.sum))
+
+		snapshot[i] = StageMetrics{
+			Stage:          s.name,
+			Workers:        s.workers,
+			Buffer:         s.buffer,
+			In:             atomic.LoadInt64(&m.in),
+			Out:            atomic.LoadInt64(&m.out),
+			Errors:         atomic.LoadInt64(&m.errors),
+			Processing:     h,
+			BlockedReceive: time.Duration(atomic.LoadInt64(&m.blockedReceive)),
+			BlockedSend:    time.Duration(atomic.LoadInt64(&m.blockedSend)),
+			Queued:         s.queued(),
+		}
+		if out := snapshot[i].Out; out > 0 {
+			snapshot[i].MeanQueued = float64(atomic.LoadInt64(&m.queued)) / float64(out)
+		}
+	}
+	return snapshot
+}
+
+// WriteMetrics writes the snapshot as a table, one stage per line.
+func (p *Pipeline) WriteMetrics(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "stage\tworkers\tin\tout\terrors\tmean\tp99\tblocked recv\tblocked send\tqueued\tbuffer\t")
+	for _, m := range p.Metrics() {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%v\t%v\t%v\t%v\t%.1f\t%d\t\n",
+			m.Stage, m.Workers, m.In, m.Out, m.Errors, m.Processing.Mean(), m.Processing.Quantile(0.99),
+			m.BlockedReceive.Round(time.Microsecond), m.BlockedSend.Round(time.Microsecond), m.MeanQueued, m.Buffer)
+	}
+	return tw.Flush()
+}
+
+// EnableProfiles gives every stage, now and later, a pprof profile named prefix.stage, got from lookup;
+// pass newProfIfNotDef from example-6.go. While one of a stage's workers is blocked its stack is in the
+// profile, ending in blockedOnReceive or blockedOnSend, so the profile's count is how many of the stage's
+// workers are waiting right now and on which side.
+func (p *Pipeline) EnableProfiles(prefix string, lookup func(name string) *pprof.Profile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.profilePrefix, p.lookup = prefix, lookup
+	for _, s := range p.stages {
+		s.profile.Store(lookup(prefix + "." + s.name))
+	}
+}
+
+func blockedOnReceive[T any](w *worker, done <-chan interface{}, c <-chan Result[T]) (Result[T], bool) {
+	if prof := w.stage.profile.Load(); prof != nil {
+		prof.Add(w, 0) //Skip nothing, so this function is the top frame and says which way the worker is blocked.
+		defer prof.Remove(w)
+	}
+	select {
+	case <-done:
+		return Result[T]{}, false
+	case r, ok := <-c:
+		return r, ok
+	}
+}
+
+// blockedOnSend waits until one of outs takes r, and says which. A tee passes both its outputs, the one that
+// already has r as nil; every other stage passes just its own.
+func blockedOnSend[T any](w *worker, done <-chan interface{}, r Result[T], outs ...chan<- Result[T]) (int, bool) {
+	if prof := w.stage.profile.Load(); prof != nil {
+		prof.Add(w, 0)
+		defer prof.Remove(w)
+	}
+	var second chan<- Result[T]
+	if len(outs) > 1 {
+		second = outs[1]
+	}
+	select {
+	case <-done:
+		return 0, false
+	case outs[0] <- r:
+		return 0, true
+	case second <- r:
+		return 1, true
+	}
+}
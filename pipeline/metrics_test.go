@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"bytes"
+	"runtime/pprof"
+	"strings"
+	"testing"
+	"time"
+)
+
+func lookupProfile(name string) *pprof.Profile { //The same as newProfIfNotDef in example-6.go.
+	prof := pprof.Lookup(name)
+	if prof == nil {
+		prof = pprof.NewProfile(name)
+	}
+	return prof
+}
+
+func TestMetricsFindTheBottleneck(t *testing.T) {
+	p := New()
+	slow := Map(count(p, 20), "slow", Options{Buffer: 4}, func(v int) (int, error) {
+		time.Sleep(2 * time.Millisecond)
+		return v, nil
+	})
+	fast := Map(slow, "fast", Options{}, func(v int) (int, error) { return v, nil })
+	if _, err := Collect(fast); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := p.Metrics()
+	if len(metrics) != 3 {
+		t.Fatalf("got metrics for %d stages, want 3", len(metrics))
+	}
+	gen, sm, fm := metrics[0], metrics[1], metrics[2]
+	if gen.Stage != "count" || sm.Stage != "slow" || fm.Stage != "fast" {
+		t.Fatalf("stages are %s, %s, %s", gen.Stage, sm.Stage, fm.Stage)
+	}
+	if gen.Out != 20 || sm.In != 20 || sm.Out != 20 || fm.In != 20 || fm.Out != 20 {
+		t.Fatalf("counts don't add up: %+v", metrics)
+	}
+	if sm.Processing.Count != 20 || sm.Processing.Mean() < 2*time.Millisecond || sm.Processing.Quantile(0.5) != 4*time.Millisecond {
+		t.Fatalf("slow processing: count %d, mean %v, median %v", sm.Processing.Count, sm.Processing.Mean(), sm.Processing.Quantile(0.5))
+	}
+	if gen.BlockedSend < 20*time.Millisecond { //The generator spends its life waiting on the slow stage...
+		t.Fatalf("count was blocked on send for %v", gen.BlockedSend)
+	}
+	if fm.BlockedReceive < 20*time.Millisecond { //...and so does the stage after it.
+		t.Fatalf("fast was blocked on receive for %v", fm.BlockedReceive)
+	}
+	if sm.BlockedReceive > gen.BlockedSend {
+		t.Fatalf("slow waited %v for input, longer than count waited to give it some (%v)", sm.BlockedReceive, gen.BlockedSend)
+	}
+
+	var table bytes.Buffer
+	if err := p.WriteMetrics(&table); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(table.String()), "\n"); len(lines) != 4 || !strings.Contains(lines[2], "slow") {
+		t.Fatalf("WriteMetrics wrote:\n%s", table.String())
+	}
+}
+
+func TestMetricsBufferOccupancy(t *testing.T) {
+	p := New()
+	full := Map(count(p, 10), "full", Options{Buffer: 10}, func(v int) (int, error) { return v, nil })
+
+	deadline := time.Now().Add(time.Second)
+	for p.Metrics()[1].Queued < 10 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	m := p.Metrics()[1]
+	if m.Queued != 10 || m.MeanQueued != 5.5 { //Nobody has read yet, so the buffer held 1, 2, ... 10 after each send.
+		t.Fatalf("Queued = %d, MeanQueued = %v, want 10 and 5.5", m.Queued, m.MeanQueued)
+	}
+	Collect(full)
+}
+
+func TestEnableProfiles(t *testing.T) {
+	p := New()
+	p.EnableProfiles("pipeline_test_early", lookupProfile)
+	in := make(chan int)
+	Map(From(p, "in", in), "stuck", Options{}, func(v int) (int, error) { return v, nil })
+	waiting := Map(From(p, "never", make(chan int)), "waiting", Options{}, func(v int) (int, error) { return v, nil })
+	p.EnableProfiles("pipeline_test", lookupProfile) //Switching after the stages exist applies to them too.
+
+	in <- 1 //Nobody reads stuck's output, so its worker blocks on send with 1, and then the source blocks on send with 2.
+	in <- 2
+	profiles := map[string]string{
+		"pipeline_test.in":      "blockedOnSend",
+		"pipeline_test.stuck":   "blockedOnSend",
+		"pipeline_test.waiting": "blockedOnReceive",
+	}
+	for name, frame := range profiles {
+		prof := pprof.Lookup(name)
+		var text bytes.Buffer
+		deadline := time.Now().Add(time.Second)
+		for { //A worker can still be on its way out of blockedOnReceive when we first look, so wait for the right stack too.
+			text.Reset()
+			prof.WriteTo(&text, 1)
+			if (prof.Count() == 1 && strings.Contains(text.String(), frame)) || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if n := prof.Count(); n != 1 {
+			t.Fatalf("%s counts %d blocked workers, want 1", name, n)
+		}
+		if !strings.Contains(text.String(), frame) {
+			t.Fatalf("%s doesn't show %s:\n%s", name, frame, text.String())
+		}
+	}
+
+	p.Stop()
+	Collect(waiting)
+	for name := range profiles {
+		if n := pprof.Lookup(name).Count(); n != 0 {
+			t.Fatalf("%s still counts %d workers after the pipeline stopped", name, n)
+		}
+	}
+	if prof := pprof.Lookup("pipeline_test_early.stuck"); prof.Count() != 0 {
+		t.Fatalf("the old profile counts %d workers", prof.Count())
+	}
+}
+
+func TestTeeMetricsAndProfile(t *testing.T) {
+	p := New()
+	roomy1, roomy2 := Tee(count(p, 5), "roomy", Options{Buffer: 10})
+	Collect(Merge("merge", Options{}, roomy1, roomy2))
+	if m := p.Metrics()[1]; m.Out != 10 || m.BlockedSend != 0 { //Both outputs always had room, so the tee never waited.
+		t.Fatalf("roomy sent %d and was blocked on send for %v, want 10 and none", m.Out, m.BlockedSend)
+	}
+
+	p = New()
+	p.EnableProfiles("pipeline_test_tee", lookupProfile)
+	in := make(chan int)
+	execute, audit := Tee(From(p, "in", in), "tee", Options{})
+	in <- 1
+	<-execute.Out() //Nobody reads audit, so the tee is stuck on it.
+
+	prof := pprof.Lookup("pipeline_test_tee.tee")
+	var text bytes.Buffer
+	deadline := time.Now().Add(time.Second)
+	for {
+		text.Reset()
+		prof.WriteTo(&text, 1)
+		if (prof.Count() == 1 && strings.Contains(text.String(), "blockedOnSend")) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if prof.Count() != 1 || !strings.Contains(text.String(), "blockedOnSend") {
+		t.Fatalf("want the tee blocked on send in its profile:\n%s", text.String())
+	}
+	p.Stop()
+	Collect(audit)
+}
@@ -0,0 +1,416 @@
+// Package pipeline builds pipelines out of stages without wiring each one by hand. The Pipeline owns the
+// done channel and every channel between stages, so nothing has to remember to close anything, and every
+// value travels as a Result, so an error has somewhere to go other than a log line.
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Result is a value, or the error that stood in for it, on its way through a pipeline. It is the Result
+// struct from example-3's Error Handling section, made generic.
+type Result[T any] struct {
+	Value T
+	Err   error
+}
+
+// StageError says which stage an error came from.
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (err *StageError) Error() string {
+	return fmt.Sprintf("stage %s: %v", err.Stage, err.Err)
+}
+
+func (err *StageError) Unwrap() error {
+	return err.Err
+}
+
+// ErrorPolicy says what a stage does when its function returns an error.
+type ErrorPolicy int
+
+const (
+	Forward   ErrorPolicy = iota // Send the error on in a Result. Later stages pass it along untouched, so it reaches whoever reads the end.
+	Report                       // Drop the item and send the error on the stage's Errors channel.
+	CancelAll                    // Stop the whole pipeline. Wait returns the error.
+)
+
+// Options are per stage.
+type Options struct {
+	Workers int // How many goroutines run the stage. With more than one, results can come out in any order.
+	Buffer  int // The capacity of the stage's output and Errors channels.
+	OnError ErrorPolicy
+}
+
+type Pipeline struct {
+	done     chan interface{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+
+	mu            sync.Mutex
+	err           error
+	stages        []*stage
+	profilePrefix string
+	lookup        func(name string) *pprof.Profile
+}
+
+func New() *Pipeline {
+	return &Pipeline{done: make(chan interface{})}
+}
+
+// Done is closed once the pipeline has been stopped, for stage functions that block on something else.
+func (p *Pipeline) Done() <-chan interface{} {
+	return p.done
+}
+
+// Stop tells every stage to give up. It is safe to call more than once and from any goroutine.
+func (p *Pipeline) Stop() {
+	p.stopOnce.Do(func() { close(p.done) })
+}
+
+// Wait returns once every stage has finished, with the error that canceled the pipeline, if any. A stage
+// only finishes when the end of the pipeline has been read to the end or the pipeline is stopped.
+func (p *Pipeline) Wait() error {
+	p.wg.Wait()
+	p.Stop()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *Pipeline) cancel(err error) {
+	p.mu.Lock()
+	if p.err == nil {
+		p.err = err //Only the first error counts; the ones after it are most likely caused by the cancellation itself.
+	}
+	p.mu.Unlock()
+	p.Stop()
+}
+
+// Stream is the output of one stage, to be read by the next stage or by whoever is at the end.
+type Stream[T any] struct {
+	p     *Pipeline
+	stage *stage
+	ch    *channel
+	out   chan Result[T]
+}
+
+// Out is where the stage's results go. Read it to the end, or stop the pipeline, or the stages block.
+func (s *Stream[T]) Out() <-chan Result[T] {
+	return s.out
+}
+
+// Errors is where a stage with the Report policy sends its errors. Like Out, it has to be read or the
+// stage blocks once Buffer errors are waiting. It is closed when the stage finishes.
+func (s *Stream[T]) Errors() <-chan error {
+	return s.stage.errs
+}
+
+// newStream adds an output channel to st. Most stages have one; a tee has one per branch.
+func newStream[T any](p *Pipeline, st *stage) *Stream[T] {
+	out := make(chan Result[T], st.buffer)
+	return &Stream[T]{
+		p:     p,
+		stage: st,
+		ch:    p.newChannel(st, func() int { return len(out) }),
+		out:   out,
+	}
+}
+
+func (s *Stream[T]) send(w *worker, r Result[T]) bool {
+	select { //Here we only start the clock if the send can't go through straight away.
+	case s.out <- r:
+	default:
+		start := time.Now()
+		_, sent := blockedOnSend(w, s.p.done, r, s.out)
+		atomic.AddInt64(&s.stage.metrics.blockedSend, int64(time.Since(start)))
+		if !sent {
+			return false
+		}
+	}
+	s.sent()
+	return true
+}
+
+func (s *Stream[T]) sent() {
+	atomic.AddInt64(&s.stage.metrics.out, 1)
+	atomic.AddInt64(&s.stage.metrics.queued, int64(len(s.out)))
+	atomic.AddInt64(&s.ch.sent, 1)
+}
+
+func receive[T any](w *worker, done <-chan interface{}, c <-chan Result[T]) (Result[T], bool) {
+	var r Result[T]
+	var ok bool
+	select {
+	case r, ok = <-c:
+	default:
+		start := time.Now()
+		r, ok = blockedOnReceive(w, done, c)
+		atomic.AddInt64(&w.stage.metrics.blockedReceive, int64(time.Since(start)))
+	}
+	if ok {
+		atomic.AddInt64(&w.stage.metrics.in, 1)
+	}
+	return r, ok
+}
+
+// fail applies the stage's policy to err and reports whether the stage should carry on.
+func (s *Stream[T]) fail(w *worker, policy ErrorPolicy, err error) bool {
+	atomic.AddInt64(&s.stage.metrics.errors, 1)
+	err = &StageError{Stage: s.stage.name, Err: err}
+	switch policy {
+	case Report:
+		select {
+		case <-s.p.done:
+			return false
+		case s.stage.errs <- err:
+			return true
+		}
+	case CancelAll:
+		s.p.cancel(err)
+		return false
+	}
+	return s.send(w, Result[T]{Err: err})
+}
+
+// Generate starts a pipeline with a stage that runs gen once. gen calls emit for each value it produces
+// and should return as soon as emit returns false, which means the pipeline has been stopped.
+func Generate[T any](p *Pipeline, name string, opts Options, gen func(emit func(T) bool) error) *Stream[T] {
+	s := newStream[T](p, p.add("source", name, opts))
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(s.stage.errs)
+		defer close(s.out)
+		w := &worker{stage: s.stage}
+		if err := gen(func(v T) bool { return s.send(w, Result[T]{Value: v}) }); err != nil {
+			s.fail(w, opts.OnError, err)
+		}
+	}()
+	return s
+}
+
+// From starts a pipeline with the values read from in. The pipeline never closes in; it only stops reading it.
+func From[T any](p *Pipeline, name string, in <-chan T) *Stream[T] {
+	return Generate(p, name, Options{}, func(emit func(T) bool) error {
+		for {
+			select {
+			case <-p.done:
+				return nil
+			case v, ok := <-in:
+				if !ok || !emit(v) {
+					return nil
+				}
+			}
+		}
+	})
+}
+
+// Map adds a stage that runs fn on each value from in. Results that already carry an error skip fn and
+// are passed straight on.
+func Map[T, R any](in *Stream[T], name string, opts Options, fn func(T) (R, error)) *Stream[R] {
+	p := in.p
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	s := newStream[R](p, p.add("map", name, opts, in.ch))
+
+	var workers sync.WaitGroup
+	workers.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go func() {
+			defer workers.Done()
+			w := &worker{stage: s.stage}
+			for {
+				r, ok := receive(w, p.done, in.out)
+				if !ok {
+					return
+				}
+				if r.Err != nil {
+					if !s.send(w, Result[R]{Err: r.Err}) {
+						return
+					}
+					continue
+				}
+				start := time.Now()
+				v, err := fn(r.Value)
+				s.stage.metrics.processing.observe(time.Since(start))
+				if err != nil {
+					if !s.fail(w, opts.OnError, err) {
+						return
+					}
+					continue
+				}
+				if !s.send(w, Result[R]{Value: v}) {
+					return
+				}
+			}
+		}()
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		workers.Wait() //Here the last worker out closes the stage's channels, the way fanIn closes its multiplexed stream.
+		close(s.out)
+		close(s.stage.errs)
+	}()
+	return s
+}
+
+// Tee adds a stage that sends everything from in, errors included, to both of its outputs. Like the
+// book's tee, it waits for both outputs to take a value before reading the next, in whichever order they do.
+func Tee[T any](in *Stream[T], name string, opts Options) (*Stream[T], *Stream[T]) {
+	p := in.p
+	opts.Workers = 1
+	st := p.add("tee", name, opts, in.ch)
+	s1, s2 := newStream[T](p, st), newStream[T](p, st)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(st.errs)
+		defer close(s2.out)
+		defer close(s1.out)
+		w := &worker{stage: st}
+		for {
+			r, ok := receive(w, p.done, in.out)
+			if !ok {
+				return
+			}
+			var out1, out2 = s1.out, s2.out
+			for out1 != nil || out2 != nil {
+				select { //Here we hand r to whichever outputs have room right now, and only start the clock if neither has.
+				case out1 <- r:
+					out1 = nil
+					s1.sent()
+					continue
+				case out2 <- r:
+					out2 = nil
+					s2.sent()
+					continue
+				default:
+				}
+				start := time.Now()
+				which, sent := blockedOnSend(w, p.done, r, out1, out2)
+				atomic.AddInt64(&st.metrics.blockedSend, int64(time.Since(start)))
+				if !sent {
+					return
+				}
+				if which == 0 {
+					out1 = nil
+					s1.sent()
+				} else {
+					out2 = nil
+					s2.sent()
+				}
+			}
+		}
+	}()
+	return s1, s2
+}
+
+// Merge adds a fan-in stage that sends everything from first and rest on one output, in whatever order it
+// arrives.
+func Merge[T any](name string, opts Options, first *Stream[T], rest ...*Stream[T]) *Stream[T] {
+	p := first.p
+	ins := append([]*Stream[T]{first}, rest...)
+	inputs := make([]*channel, len(ins))
+	for i, in := range ins {
+		inputs[i] = in.ch
+	}
+	opts.Workers = len(ins)
+	s := newStream[T](p, p.add("merge", name, opts, inputs...))
+
+	var workers sync.WaitGroup
+	workers.Add(len(ins))
+	for _, in := range ins {
+		go func(in *Stream[T]) {
+			defer workers.Done()
+			w := &worker{stage: s.stage}
+			for {
+				r, ok := receive(w, p.done, in.out)
+				if !ok || !s.send(w, r) {
+					return
+				}
+			}
+		}(in)
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		workers.Wait()
+		close(s.out)
+		close(s.stage.errs)
+	}()
+	return s
+}
+
+// Bridge adds a stage that reads each channel from in to the end, in turn, and sends its values on one
+// output. The inner channels belong to whoever sent them; Bridge only stops reading them.
+func Bridge[T any](in *Stream[<-chan T], name string, opts Options) *Stream[T] {
+	p := in.p
+	opts.Workers = 1
+	s := newStream[T](p, p.add("bridge", name, opts, in.ch))
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(s.stage.errs)
+		defer close(s.out)
+		w := &worker{stage: s.stage}
+		for {
+			r, ok := receive(w, p.done, in.out)
+			if !ok {
+				return
+			}
+			if r.Err != nil {
+				if !s.send(w, Result[T]{Err: r.Err}) {
+					return
+				}
+				continue
+			}
+			for stream := r.Value; stream != nil; {
+				select {
+				case <-p.done:
+					return
+				case v, ok := <-stream:
+					if !ok {
+						stream = nil //This one is finished; on to the next channel from in.
+						continue
+					}
+					if !s.send(w, Result[T]{Value: v}) {
+						return
+					}
+				}
+			}
+		}
+	}()
+	return s
+}
+
+// Collect reads s to the end and waits for the pipeline to finish. It returns the values that made it
+// through and every error that was forwarded to the end or canceled the pipeline.
+func Collect[T any](s *Stream[T]) ([]T, error) {
+	var values []T
+	var errs []error
+	for r := range s.out {
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+			continue
+		}
+		values = append(values, r.Value)
+	}
+	if err := s.p.Wait(); err != nil {
+		errs = append(errs, err)
+	}
+	return values, errors.Join(errs...)
+}
@@ -0,0 +1,173 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errOdd = errors.New("odd")
+
+func count(p *Pipeline, n int) *Stream[int] {
+	return Generate(p, "count", Options{}, func(emit func(int) bool) error {
+		for i := 0; i < n; i++ {
+			if !emit(i) {
+				return nil
+			}
+		}
+		return nil
+	})
+}
+
+func rejectOdd(v int) (int, error) {
+	if v%2 == 1 {
+		return 0, errOdd
+	}
+	return v, nil
+}
+
+func TestPipelineForwardsErrors(t *testing.T) {
+	p := New()
+	checked := Map(count(p, 6), "check", Options{}, rejectOdd)
+	strs := Map(checked, "format", Options{}, func(v int) (string, error) { return strconv.Itoa(v), nil })
+
+	var got []string
+	var errs []error
+	for r := range strs.Out() {
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+			continue
+		}
+		got = append(got, r.Value)
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatalf("Wait() = %v", err)
+	}
+	if want := []string{"0", "2", "4"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if len(errs) != 3 {
+		t.Fatalf("got %d errors, want 3: %v", len(errs), errs)
+	}
+	var stageErr *StageError
+	if !errors.As(errs[0], &stageErr) || stageErr.Stage != "check" || !errors.Is(errs[0], errOdd) {
+		t.Fatalf("got %v, want the odd error from the check stage", errs[0])
+	}
+}
+
+func TestPipelineReportsErrors(t *testing.T) {
+	p := New()
+	checked := Map(count(p, 6), "check", Options{OnError: Report, Buffer: 3}, rejectOdd) //Errors' buffer holds all three, so we can read it afterwards.
+
+	values, err := Collect(checked)
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+	if want := []int{0, 2, 4}; !reflect.DeepEqual(values, want) {
+		t.Fatalf("got %v, want %v", values, want)
+	}
+	var reported []error
+	for err := range checked.Errors() {
+		reported = append(reported, err)
+	}
+	if len(reported) != 3 || !errors.Is(reported[0], errOdd) {
+		t.Fatalf("Errors() gave %v, want three odd errors", reported)
+	}
+}
+
+func TestPipelineCancelAll(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	p := New()
+	var processed int32
+	checked := Map(count(p, 1000000), "check", Options{OnError: CancelAll, Workers: 4}, func(v int) (int, error) {
+		atomic.AddInt32(&processed, 1)
+		if v == 10 {
+			return 0, fmt.Errorf("item %d: %w", v, errOdd)
+		}
+		return v, nil
+	})
+	last := Map(checked, "double", Options{Buffer: 8}, func(v int) (int, error) { return v * 2, nil })
+
+	_, err := Collect(last)
+	if !errors.Is(err, errOdd) {
+		t.Fatalf("Collect() error = %v, want the error that canceled the pipeline", err)
+	}
+	if n := atomic.LoadInt32(&processed); n > 1000 {
+		t.Fatalf("%d items were processed; the pipeline didn't stop", n)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("%d goroutines running after the pipeline was canceled, want %d", n, before)
+	}
+}
+
+func TestPipelineWorkers(t *testing.T) {
+	p := New()
+	var running, peak int32
+	slow := Map(count(p, 40), "slow", Options{Workers: 8, Buffer: 8}, func(v int) (int, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if n <= old || atomic.CompareAndSwapInt32(&peak, old, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return v, nil
+	})
+
+	values, err := Collect(slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(values)
+	if len(values) != 40 || values[39] != 39 {
+		t.Fatalf("got %v, want 0 to 39", values)
+	}
+	if peak < 2 || peak > 8 {
+		t.Fatalf("at most %d items ran at once, want between 2 and 8", peak)
+	}
+}
+
+func TestPipelineStop(t *testing.T) {
+	p := New()
+	in := make(chan int)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case in <- i:
+			case <-time.After(time.Second):
+				return
+			}
+		}
+	}()
+	out := Map(From(p, "in", in), "identity", Options{}, func(v int) (int, error) { return v, nil })
+	<-out.Out()
+	p.Stop()
+
+	stopped := make(chan error)
+	go func() {
+		_, err := Collect(out)
+		stopped <- err
+	}()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("Collect() error = %v after Stop, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the pipeline did not finish after Stop")
+	}
+}
@@ -0,0 +1,190 @@
+// Package pubsub is an in-process broker that fans events out to subsystems by topic, in place of the
+// hand-built trees of tees we kept writing.
+//
+// Topics are dot-separated, like "jobs.42.done". A subscription's pattern may use "*" to match exactly one
+// segment and, as its last segment, ">" to match one or more.
+package pubsub
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Delivery says what happens when a subscriber's buffer is full.
+type Delivery int
+
+const (
+	AtMostOnce Delivery = iota // The message is dropped for that subscriber and counted; the publisher never waits for it.
+	Blocking                   // The publisher waits until the subscriber has room, unsubscribes, or done is closed.
+)
+
+type Message[T any] struct {
+	Topic string
+	Value T
+}
+
+type Broker[T any] struct {
+	done <-chan interface{}
+
+	mu   sync.RWMutex
+	subs map[*Subscription[T]]bool
+}
+
+// NewBroker returns a broker whose subscriptions all end when done is closed.
+func NewBroker[T any](done <-chan interface{}) *Broker[T] {
+	return &Broker[T]{done: done, subs: make(map[*Subscription[T]]bool)}
+}
+
+// Subscription delivers the messages whose topic matched its pattern on C, in the order they were
+// published. C is closed once the subscription ends.
+type Subscription[T any] struct {
+	C <-chan Message[T]
+
+	broker   *Broker[T]
+	pattern  []string
+	delivery Delivery
+	out      chan Message[T]
+	inbox    chan Message[T] // Blocking only: publishers hand their messages to run through it.
+	quit     chan struct{}
+	exited   chan struct{}
+	once     sync.Once
+	dropped  int64
+}
+
+// Subscribe starts a subscription to every topic matching pattern, holding up to buffer messages its
+// reader hasn't taken yet.
+func (b *Broker[T]) Subscribe(pattern string, buffer int, delivery Delivery) (*Subscription[T], error) {
+	segments := strings.Split(pattern, ".")
+	for i, segment := range segments {
+		if segment == "" || (segment == ">" && i != len(segments)-1) {
+			return nil, fmt.Errorf("pubsub: bad pattern %q", pattern)
+		}
+	}
+	if buffer < 1 {
+		buffer = 1
+	}
+
+	s := &Subscription[T]{
+		broker:   b,
+		pattern:  segments,
+		delivery: delivery,
+		quit:     make(chan struct{}),
+		exited:   make(chan struct{}),
+	}
+	if delivery == AtMostOnce {
+		s.out = make(chan Message[T], buffer) //Publishers put messages straight into C's buffer, or drop them, without waiting for anyone.
+	} else {
+		s.out = make(chan Message[T])
+		s.inbox = make(chan Message[T])
+	}
+	s.C = s.out
+	b.mu.Lock()
+	b.subs[s] = true
+	b.mu.Unlock()
+
+	go s.run(buffer)
+	return s, nil
+}
+
+// Unsubscribe ends the subscription and returns once its goroutine has exited and C has been closed. It
+// is safe to call more than once, and from the goroutine reading C.
+func (s *Subscription[T]) Unsubscribe() {
+	s.once.Do(func() { close(s.quit) })
+	<-s.exited
+}
+
+// Dropped is how many messages an AtMostOnce subscription has thrown away because its buffer was full.
+func (s *Subscription[T]) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// run ends the subscription, however it ends. For a Blocking subscription it also holds the subscriber's
+// buffer itself, so publishers only ever hand a message over to it and nobody else sends on C.
+func (s *Subscription[T]) run(buffer int) {
+	defer close(s.exited)
+	defer func() {
+		s.broker.mu.Lock() //Here we leave the broker and close C under its lock, so no publisher can be sending on C as we close it.
+		delete(s.broker.subs, s)
+		close(s.out)
+		s.broker.mu.Unlock()
+	}()
+
+	if s.delivery == AtMostOnce {
+		select {
+		case <-s.broker.done:
+		case <-s.quit:
+		}
+		return
+	}
+
+	queue := make([]Message[T], 0, buffer)
+	for {
+		inbox := s.inbox
+		if len(queue) == buffer {
+			inbox = nil //Full, so we stop taking messages and publishers wait.
+		}
+		var send chan<- Message[T]
+		var next Message[T]
+		if len(queue) > 0 {
+			send, next = s.out, queue[0]
+		}
+
+		select {
+		case <-s.broker.done:
+			return
+		case <-s.quit:
+			return
+		case m := <-inbox:
+			queue = append(queue, m)
+		case send <- next:
+			queue = queue[1:]
+		}
+	}
+}
+
+// Publish hands v to every subscription whose pattern matches topic. It only waits for Blocking
+// subscriptions, one after another, once every AtMostOnce subscription has had its copy or dropped it.
+func (b *Broker[T]) Publish(topic string, v T) {
+	segments := strings.Split(topic, ".")
+	m := Message[T]{Topic: topic, Value: v}
+	b.mu.RLock()
+	var blocking []*Subscription[T]
+	for s := range b.subs {
+		if !match(s.pattern, segments) {
+			continue
+		}
+		if s.delivery == Blocking {
+			blocking = append(blocking, s) //We don't wait while holding the lock, or a slow subscriber would hold up Subscribe too.
+			continue
+		}
+		select {
+		case s.out <- m:
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, s := range blocking {
+		select {
+		case s.inbox <- m:
+		case <-s.quit:
+		case <-b.done:
+			return
+		}
+	}
+}
+
+func match(pattern, topic []string) bool {
+	for i, segment := range pattern {
+		if segment == ">" {
+			return len(topic) > i
+		}
+		if i >= len(topic) || (segment != "*" && segment != topic[i]) {
+			return false
+		}
+	}
+	return len(pattern) == len(topic)
+}
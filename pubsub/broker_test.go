@@ -0,0 +1,202 @@
+package pubsub
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"jobs.42.done", "jobs.42.done", true},
+		{"jobs.42.done", "jobs.43.done", false},
+		{"jobs.*.done", "jobs.43.done", true},
+		{"jobs.*.done", "jobs.43.failed", false},
+		{"jobs.*", "jobs.43.done", false},
+		{"jobs.>", "jobs.43.done", true},
+		{"jobs.>", "jobs.43", true},
+		{"jobs.>", "jobs", false},
+		{"*.*.done", "jobs.43.done", true},
+		{"jobs.42", "jobs.42.done", false},
+	}
+	for _, tt := range tests {
+		if got := match(strings.Split(tt.pattern, "."), strings.Split(tt.topic, ".")); got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestSubscribeRejectsBadPatterns(t *testing.T) {
+	b := NewBroker[int](nil)
+	for _, pattern := range []string{"", "jobs..done", "jobs.>.done"} {
+		if _, err := b.Subscribe(pattern, 1, AtMostOnce); err == nil {
+			t.Errorf("Subscribe(%q) succeeded, want an error", pattern)
+		}
+	}
+}
+
+func receive(t *testing.T, s *Subscription[int]) Message[int] {
+	t.Helper()
+	select {
+	case m := <-s.C:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("no message")
+	}
+	return Message[int]{}
+}
+
+func TestPublishRoutesByTopic(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+	b := NewBroker[int](done)
+
+	all, _ := b.Subscribe("jobs.>", 10, Blocking)
+	finished, _ := b.Subscribe("jobs.*.done", 10, Blocking)
+
+	b.Publish("jobs.1.started", 1)
+	b.Publish("jobs.1.done", 2)
+	b.Publish("users.1.created", 3)
+
+	if m := receive(t, all); m.Topic != "jobs.1.started" || m.Value != 1 {
+		t.Fatalf("all got %+v first", m)
+	}
+	if m := receive(t, all); m.Value != 2 {
+		t.Fatalf("all got %+v second", m)
+	}
+	if m := receive(t, finished); m.Topic != "jobs.1.done" || m.Value != 2 {
+		t.Fatalf("finished got %+v", m)
+	}
+	select {
+	case m := <-all.C:
+		t.Fatalf("all got %+v from a topic it doesn't match", m)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestAtMostOnceDropsForSlowSubscriber(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+	b := NewBroker[int](done)
+
+	slow, _ := b.Subscribe("metrics", 3, AtMostOnce)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		b.Publish("metrics", i)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("publishing took %v; an at-most-once subscriber must not hold up the publisher", elapsed)
+	}
+	for want := 0; want < 3; want++ {
+		if m := receive(t, slow); m.Value != want {
+			t.Fatalf("got %d, want %d", m.Value, want)
+		}
+	}
+	if n := slow.Dropped(); n != 7 {
+		t.Fatalf("Dropped() = %d, want 7", n)
+	}
+}
+
+func TestBlockingDeliveryWaitsForRoom(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+	b := NewBroker[int](done)
+
+	s, _ := b.Subscribe("audit", 2, Blocking)
+	published := make(chan int, 5)
+	go func() {
+		for i := 0; i < 5; i++ {
+			b.Publish("audit", i)
+			published <- i
+		}
+		close(published)
+	}()
+
+	for i := 0; i < 2; i++ {
+		<-published
+	}
+	select {
+	case i := <-published:
+		t.Fatalf("message %d was published while the subscriber's buffer was full", i)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	for want := 0; want < 5; want++ {
+		if m := receive(t, s); m.Value != want {
+			t.Fatalf("got %d, want %d", m.Value, want)
+		}
+	}
+	for range published {
+	}
+	if n := s.Dropped(); n != 0 {
+		t.Fatalf("Dropped() = %d, want 0", n)
+	}
+}
+
+func TestUnsubscribeLeavesNothingBehind(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+	b := NewBroker[int](done)
+	before := runtime.NumGoroutine()
+
+	s, _ := b.Subscribe("audit", 1, Blocking)
+	publishing := make(chan struct{})
+	go func() {
+		defer close(publishing)
+		for i := 0; i < 3; i++ {
+			b.Publish("audit", i) //Nobody reads, so this blocks until we unsubscribe.
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	s.Unsubscribe()
+	s.Unsubscribe()
+	<-publishing
+
+	for range s.C {
+	}
+	b.Publish("audit", 4)
+	if n := len(b.subs); n != 0 {
+		t.Fatalf("the broker still holds %d subscriptions", n)
+	}
+	deadline := time.Now().Add(time.Second) //exited is closed just before the goroutine returns, so give the runtime a moment to notice.
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("%d goroutines running after unsubscribing, want %d", n, before)
+	}
+}
+
+func TestDoneEndsEverySubscription(t *testing.T) {
+	done := make(chan interface{})
+	b := NewBroker[int](done)
+	s1, _ := b.Subscribe("a", 1, Blocking)
+	s2, _ := b.Subscribe(">", 1, AtMostOnce)
+	b.Publish("a", 1)
+	close(done)
+
+	for _, s := range []*Subscription[int]{s1, s2} {
+		closed := make(chan struct{})
+		go func(s *Subscription[int]) {
+			for range s.C {
+			}
+			close(closed)
+		}(s)
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("a subscription was still open after done was closed")
+		}
+	}
+	b.Publish("a", 2) //Must not block with every subscriber gone.
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if n := len(b.subs); n != 0 {
+		t.Fatalf("the broker still holds %d subscriptions after done was closed", n)
+	}
+}
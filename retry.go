@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// run -> go test retry_test.go retry.go example-4.go
+
+type backoff struct {
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	MaxAttempts int              // Zero means no limit on attempts; MaxElapsed or the context still applies.
+	MaxElapsed  time.Duration    // Zero means no limit on total time.
+	Budget      RateLimiter      // Optional. Every retry, but not the first attempt, has to wait for a token, so a burst of failures can't become a burst of retries.
+	ShouldRetry func(error) bool // Defaults to isTransient, which the job runner retries by too.
+}
+
+func retry(ctx context.Context, policy backoff, fn func(ctx context.Context) error) error {
+	if policy.ShouldRetry == nil {
+		policy.ShouldRetry = isTransient
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = 100 * time.Millisecond
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = 30 * time.Second
+	}
+	if policy.BaseDelay > policy.MaxDelay {
+		policy.BaseDelay = policy.MaxDelay //An explicit cap wins, even over the first delay.
+	}
+	var deadline time.Time
+	if policy.MaxElapsed > 0 {
+		deadline = time.Now().Add(policy.MaxElapsed)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || !policy.ShouldRetry(err) {
+			return err
+		}
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		delay := fullJitter(policy.BaseDelay, policy.MaxDelay, attempt)
+		if !deadline.IsZero() && time.Now().Add(delay).After(deadline) {
+			return err //Here we give up early rather than sleep past MaxElapsed only to be told we're out of time.
+		}
+		select {
+		case <-ctx.Done():
+			return err //We hand back the last error from fn rather than ctx.Err(); it says more about why we were retrying.
+		case <-time.After(delay):
+		}
+		if policy.Budget != nil {
+			if budgetErr := policy.Budget.Wait(ctx); budgetErr != nil {
+				return err
+			}
+		}
+	}
+}
+
+func fullJitter(base, max time.Duration, attempt int) time.Duration {
+	ceiling := max
+	if shift := uint(attempt - 1); shift < 32 && base<<shift < max && base<<shift > 0 {
+		ceiling = base << shift
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1)) //Full jitter: sleep anywhere between zero and the exponential ceiling, so clients that failed together don't retry together.
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return true }
+
+func transientErr(message string) error {
+	err := wrapError(nil, "%s", message)
+	err.Misc["transient"] = true
+	return err
+}
+
+func TestIsTransient(t *testing.T) {
+	taggedFalse := wrapError(timeoutErr{}, "bad request")
+	taggedFalse.Misc["transient"] = false
+
+	for _, tc := range []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"transient MyError", transientErr("connection reset"), true},
+		{"net timeout", fmt.Errorf("dial: %w", timeoutErr{}), true},
+		{"low-level net timeout", LowLevelErr{wrapError(timeoutErr{}, "read failed")}, true},
+		{"MyError tagged permanent", taggedFalse, false},
+		{"intermediate config error", runJob("1"), false},
+		{"intermediate over a timeout", IntermediateErr{wrapError(LowLevelErr{wrapError(timeoutErr{}, "read failed")}, "bad config")}, false},
+		{"transient intermediate", IntermediateErr{transientErr("try again later")}, true},
+		{"raw", errors.New("boom"), false},
+	} {
+		if got := isTransient(tc.err); got != tc.expected {
+			t.Errorf("%s: expected %v, but received %v", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestRetry_StopsOnSuccess(t *testing.T) {
+	calls := 0
+	err := retry(context.Background(), backoff{BaseDelay: time.Millisecond, MaxAttempts: 5}, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return transientErr("busy")
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("expected success on the third call, got %v after %v calls", err, calls)
+	}
+}
+
+func TestRetry_NeverRetriesConfigErrors(t *testing.T) {
+	calls := 0
+	err := retry(context.Background(), backoff{BaseDelay: time.Millisecond, MaxAttempts: 5}, func(ctx context.Context) error {
+		calls++
+		return runJob("1")
+	})
+	if calls != 1 || !errors.Is(err, IntermediateErr{}) {
+		t.Errorf("expected a single call returning the IntermediateErr, got %v after %v calls", err, calls)
+	}
+}
+
+func TestRetry_MaxAttempts(t *testing.T) {
+	calls := 0
+	err := retry(context.Background(), backoff{BaseDelay: time.Millisecond, MaxAttempts: 4}, func(ctx context.Context) error {
+		calls++
+		return transientErr(fmt.Sprintf("busy %d", calls))
+	})
+	if calls != 4 || err.Error() != "busy 4" {
+		t.Errorf("expected the last of 4 errors, got %v after %v calls", err, calls)
+	}
+}
+
+func TestRetry_MaxElapsed(t *testing.T) {
+	start := time.Now()
+	retry(context.Background(), backoff{BaseDelay: 10 * time.Millisecond, MaxElapsed: 100 * time.Millisecond}, func(ctx context.Context) error {
+		return transientErr("busy")
+	})
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("expected to give up within MaxElapsed, took %v", elapsed)
+	}
+}
+
+func TestRetry_HonorsContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := retry(ctx, backoff{BaseDelay: time.Second, MaxDelay: time.Second}, func(ctx context.Context) error {
+		return transientErr("busy")
+	})
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond || err == nil {
+		t.Errorf("expected to stop with the context, got %v after %v", err, elapsed)
+	}
+}
+
+func TestRetry_MaxDelayCapsBaseDelay(t *testing.T) {
+	start := time.Now()
+	calls := 0
+	retry(context.Background(), backoff{BaseDelay: time.Second, MaxDelay: 5 * time.Millisecond, MaxAttempts: 4}, func(ctx context.Context) error {
+		calls++
+		return transientErr("busy")
+	})
+	if elapsed := time.Since(start); calls != 4 || elapsed > 200*time.Millisecond { //Three waits of at most 5ms each, not a second, let alone 30.
+		t.Errorf("expected MaxDelay to cap every wait, got %v calls in %v", calls, elapsed)
+	}
+}
+
+func TestRetry_DrawsFromBudget(t *testing.T) {
+	budget := MultiLimiter(rate.NewLimiter(rate.Limit(1), 2)) //Two retries up front, then one a second.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	calls := 0
+	retry(ctx, backoff{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Budget: budget}, func(ctx context.Context) error {
+		calls++
+		return transientErr("busy")
+	})
+	if calls != 3 {
+		t.Errorf("expected the first call plus two budgeted retries, got %v calls", calls)
+	}
+}
+
+func TestFullJitter_StaysUnderCeiling(t *testing.T) {
+	for attempt := 1; attempt < 100; attempt++ {
+		if d := fullJitter(10*time.Millisecond, time.Second, attempt); d < 0 || d > time.Second {
+			t.Fatalf("attempt %v: delay %v out of range", attempt, d)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if d := fullJitter(10*time.Millisecond, time.Second, 1); d > 10*time.Millisecond {
+			t.Fatalf("expected first delay under base, got %v", d)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package stages
+
+import "time"
+
+// Batch groups values from in into slices of up to size, sending a batch as soon as it is full or maxWait
+// after its first value arrived, whichever comes first, so a trickle of values is never held up for long.
+// What is left over is flushed when in closes. When done closes the partial batch is flushed too if the
+// output's one-slot buffer is free; we never block on a consumer that may already have gone.
+func Batch[T any](done <-chan interface{}, in <-chan T, size int, maxWait time.Duration) <-chan []T {
+	if size < 1 {
+		size = 1
+	}
+	out := make(chan []T, 1)
+	go func() {
+		defer close(out)
+
+		var batch []T
+		timer := time.NewTimer(maxWait)
+		timer.Stop()
+		var expired <-chan time.Time //nil while the batch is empty, so we only wait on the timer while there is something to flush.
+
+		flush := func() bool {
+			if expired != nil && !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			expired = nil
+			select {
+			case <-done:
+				return false
+			case out <- batch:
+			}
+			batch = nil //Here we start a fresh slice rather than reusing the old one, which now belongs to the consumer.
+			return true
+		}
+
+		for {
+			select {
+			case <-done:
+				if len(batch) > 0 {
+					select {
+					case out <- batch:
+					default:
+					}
+				}
+				return
+			case <-expired:
+				expired = nil
+				if !flush() {
+					return
+				}
+			case v, ok := <-in:
+				if !ok {
+					if len(batch) > 0 {
+						flush()
+					}
+					return
+				}
+				if len(batch) == 0 {
+					batch = make([]T, 0, size)
+					timer.Reset(maxWait)
+					expired = timer.C
+				}
+				batch = append(batch, v)
+				if len(batch) == size && !flush() {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Unbatch undoes Batch, sending the values of each slice from in one at a time.
+func Unbatch[T any](done <-chan interface{}, in <-chan []T) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for batch := range OrDone(done, in) {
+			for _, v := range batch {
+				select {
+				case <-done:
+					return
+				case out <- v:
+				}
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,66 @@
+package stages
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBatchBySize(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	got := collect(Batch(done, from(1, 2, 3, 4, 5, 6, 7), 3, time.Hour))
+	if want := [][]int{{1, 2, 3}, {4, 5, 6}, {7}}; !reflect.DeepEqual(got, want) { //The last batch is flushed when in closes.
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestBatchByTime(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	in := make(chan int)
+	out := Batch(done, in, 100, 20*time.Millisecond)
+
+	start := time.Now()
+	in <- 1
+	in <- 2
+	batch := <-out
+	if waited := time.Since(start); waited < 20*time.Millisecond || waited > time.Second {
+		t.Fatalf("batch came after %v, want about 20ms", waited)
+	}
+	if want := []int{1, 2}; !reflect.DeepEqual(batch, want) {
+		t.Fatalf("got %v, want %v", batch, want)
+	}
+
+	time.Sleep(50 * time.Millisecond) //Nothing is waiting, so the timer must not be running and no empty batch should appear.
+	in <- 3
+	close(in)
+	if got := collect(out); !reflect.DeepEqual(got, [][]int{{3}}) {
+		t.Fatalf("got %v, want [[3]]", got)
+	}
+}
+
+func TestBatchFlushesOnDone(t *testing.T) {
+	done := make(chan interface{})
+	in := make(chan int)
+	out := Batch(done, in, 10, time.Hour)
+	in <- 1
+	in <- 2
+	close(done)
+
+	if got := collect(out); !reflect.DeepEqual(got, [][]int{{1, 2}}) {
+		t.Fatalf("got %v, want [[1 2]]", got)
+	}
+}
+
+func TestUnbatch(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	got := collect(Unbatch(done, Batch(done, count(done, 10), 4, time.Hour)))
+	if want := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package stages
+
+import "sync"
+
+type sequenced[T any] struct {
+	seq   int
+	value T
+}
+
+// ParallelMap runs fn over in with the given number of workers and still sends the results in the order
+// their inputs arrived. A result that finishes early waits in a reorder buffer until everything before it
+// has been sent; at most window items are ever between being read from in and being sent, so one slow item
+// holds up the stage instead of letting the buffer grow without bound. window is raised to workers if it
+// is smaller, since otherwise some workers could never be given anything to do.
+func ParallelMap[T, R any](done <-chan interface{}, in <-chan T, workers, window int, fn func(T) R) <-chan R {
+	if workers < 1 {
+		workers = 1
+	}
+	if window < workers {
+		window = workers
+	}
+
+	tokens := make(chan struct{}, window) //One token per item in flight. The collector hands a token back each time it sends a result.
+	jobs := make(chan sequenced[T])
+	results := make(chan sequenced[R])
+
+	go func() {
+		defer close(jobs)
+		for seq := 0; ; seq++ {
+			select {
+			case <-done:
+				return
+			case tokens <- struct{}{}:
+			}
+			var v T
+			select {
+			case <-done:
+				return
+			case value, ok := <-in:
+				if !ok {
+					return
+				}
+				v = value
+			}
+			select {
+			case <-done:
+				return
+			case jobs <- sequenced[T]{seq, v}:
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				select {
+				case <-done:
+					return
+				case results <- sequenced[R]{job.seq, fn(job.value)}:
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	out := make(chan R)
+	go func() {
+		defer close(out)
+		pending := make(map[int]R, window)
+		next := 0
+		for r := range results {
+			pending[r.seq] = r.value
+			for { //Here we send every result that is now next in line, which may be a run of them once a slow item finishes.
+				v, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				select {
+				case <-done:
+					return
+				case out <- v:
+				}
+				next++
+				<-tokens
+			}
+		}
+	}()
+	return out
+}
+
+// ParallelMapUnordered runs fn over in with the given number of workers and sends each result as soon as
+// it is ready. It is the book's fan-out, fan-in, for when order doesn't matter and speed does.
+func ParallelMapUnordered[T, R any](done <-chan interface{}, in <-chan T, workers int, fn func(T) R) <-chan R {
+	if workers < 1 {
+		workers = 1
+	}
+	return FanIn(done, FanOut(done, in, workers, fn)...)
+}
@@ -0,0 +1,104 @@
+package stages
+
+import (
+	"math/rand"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func count(done <-chan interface{}, n int) <-chan int {
+	c := make(chan int)
+	go func() {
+		defer close(c)
+		for i := 0; i < n; i++ {
+			select {
+			case <-done:
+				return
+			case c <- i:
+			}
+		}
+	}()
+	return c
+}
+
+func TestParallelMapKeepsOrder(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	slowSquare := func(v int) int {
+		time.Sleep(time.Duration(rand.Intn(2000)) * time.Microsecond)
+		return v * v
+	}
+	got := collect(ParallelMap(done, count(done, 200), 8, 16, slowSquare))
+	if len(got) != 200 {
+		t.Fatalf("got %d results, want 200", len(got))
+	}
+	for i, v := range got {
+		if v != i*i {
+			t.Fatalf("got[%d] = %d, want %d", i, v, i*i)
+		}
+	}
+}
+
+func TestParallelMapBoundsReorderBuffer(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	release := make(chan struct{})
+	var started int32
+	fn := func(v int) int {
+		atomic.AddInt32(&started, 1)
+		if v == 0 {
+			<-release //Item 0 is stuck, so nothing can be sent and every later result has to wait in the buffer.
+		}
+		return v
+	}
+	out := ParallelMap(done, count(done, 100), 2, 4, fn)
+
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&started); n != 4 {
+		t.Fatalf("%d items were started while the first was stuck, want the window of 4", n)
+	}
+
+	close(release)
+	got := collect(out)
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("got[%d] = %d, want %d", i, v, i)
+		}
+	}
+	if len(got) != 100 {
+		t.Fatalf("got %d results, want 100", len(got))
+	}
+}
+
+func TestParallelMapUnordered(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	got := collect(ParallelMapUnordered(done, count(done, 100), 4, func(v int) int { return v * 2 }))
+	sort.Ints(got)
+	if len(got) != 100 {
+		t.Fatalf("got %d results, want 100", len(got))
+	}
+	for i, v := range got {
+		if v != i*2 {
+			t.Fatalf("got[%d] = %d, want %d", i, v, i*2)
+		}
+	}
+}
+
+func TestParallelMapStopsOnDone(t *testing.T) {
+	done := make(chan interface{})
+	out := ParallelMap(done, Repeat(done, 1), 4, 8, func(v int) int { return v })
+	<-out
+	close(done)
+
+	select {
+	case <-drained(out):
+	case <-time.After(time.Second):
+		t.Fatal("ParallelMap did not close its output after done was closed")
+	}
+}
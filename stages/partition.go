@@ -0,0 +1,180 @@
+package stages
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const ringReplicas = 64 //Each worker owns this many points on the ring, so keys spread evenly even with only a few workers.
+
+// Partitioned is a fan-out in which every item with the same key goes to the same worker, so items for one
+// key come out in the order they went in while different keys are worked on in parallel.
+type Partitioned[T, R any] struct {
+	done    <-chan interface{}
+	in      <-chan T
+	buffer  int
+	key     func(T) string
+	fn      func(T) R
+	out     chan R
+	resized chan struct{}
+
+	mu   sync.Mutex
+	want int
+
+	ring   ring
+	queues []chan T
+	wg     sync.WaitGroup
+}
+
+// Partition starts workers goroutines running fn, routing each item from in by a consistent hash of
+// key(item). Each worker queues up to buffer items of its own, so a busy key only holds up the keys that
+// share its worker.
+func Partition[T, R any](done <-chan interface{}, in <-chan T, workers, buffer int, key func(T) string, fn func(T) R) *Partitioned[T, R] {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Partitioned[T, R]{
+		done:    done,
+		in:      in,
+		buffer:  buffer,
+		key:     key,
+		fn:      fn,
+		out:     make(chan R),
+		resized: make(chan struct{}, 1),
+		want:    workers,
+	}
+	p.start(workers)
+	go p.route()
+	return p
+}
+
+func (p *Partitioned[T, R]) Out() <-chan R {
+	return p.out
+}
+
+// Workers is the number of workers the stage has been asked to run. A Resize takes effect between items.
+func (p *Partitioned[T, R]) Workers() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.want
+}
+
+// Resize changes the number of workers. It never blocks, so it is safe to call from the goroutine reading
+// Out. Only about 1/n of the keys change worker, and none of the items already handed to a worker is lost.
+func (p *Partitioned[T, R]) Resize(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	p.mu.Lock()
+	p.want = workers
+	p.mu.Unlock()
+	select {
+	case p.resized <- struct{}{}:
+	default: //A resize is already pending, and it will pick up the new count.
+	}
+}
+
+func (p *Partitioned[T, R]) route() {
+	defer close(p.out)
+	defer p.stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.resized:
+			p.rebalance()
+		case v, ok := <-p.in:
+			if !ok {
+				return
+			}
+			select {
+			case <-p.done:
+				return
+			case p.queues[p.ring.owner(p.key(v))] <- v:
+			}
+		}
+	}
+}
+
+func (p *Partitioned[T, R]) rebalance() {
+	want := p.Workers()
+	if want == len(p.queues) {
+		return
+	}
+	p.stop() //Here we let every worker finish what it was already given before any key moves, otherwise the new owner of a key could overtake the old one.
+	p.start(want)
+}
+
+func (p *Partitioned[T, R]) start(workers int) {
+	p.ring = newRing(workers)
+	p.queues = make([]chan T, workers)
+	p.wg.Add(workers)
+	for i := range p.queues {
+		p.queues[i] = make(chan T, p.buffer)
+		go func(queue <-chan T) {
+			defer p.wg.Done()
+			for v := range queue {
+				select {
+				case <-p.done:
+					return
+				case p.out <- p.fn(v):
+				}
+			}
+		}(p.queues[i])
+	}
+}
+
+func (p *Partitioned[T, R]) stop() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
+
+type ring struct {
+	points []uint32
+	owners []int
+}
+
+func newRing(workers int) ring {
+	type point struct {
+		hash  uint32
+		owner int
+	}
+	points := make([]point, 0, workers*ringReplicas)
+	for w := 0; w < workers; w++ {
+		for i := 0; i < ringReplicas; i++ {
+			points = append(points, point{hashKey(strconv.Itoa(w) + "#" + strconv.Itoa(i)), w})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	r := ring{points: make([]uint32, len(points)), owners: make([]int, len(points))}
+	for i, pt := range points {
+		r.points[i], r.owners[i] = pt.hash, pt.owner
+	}
+	return r
+}
+
+func (r ring) owner(key string) int {
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0 //Past the last point we wrap around to the first.
+	}
+	return r.owners[i]
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	x := h.Sum32()
+	x ^= x >> 16 //FNV on its own leaves short, similar keys like "0#1" and "0#2" close together on the ring, so we mix the bits as murmur3 does.
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
@@ -0,0 +1,122 @@
+package stages
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+type event struct {
+	user string
+	seq  int
+}
+
+func events(done <-chan interface{}, users, perUser int) <-chan event {
+	c := make(chan event)
+	go func() {
+		defer close(c)
+		for seq := 0; seq < perUser; seq++ {
+			for u := 0; u < users; u++ {
+				select {
+				case <-done:
+					return
+				case c <- event{fmt.Sprintf("user-%d", u), seq}:
+				}
+			}
+		}
+	}()
+	return c
+}
+
+func checkPerKeyOrder(t *testing.T, got []event, users, perUser int) {
+	t.Helper()
+	if len(got) != users*perUser {
+		t.Fatalf("got %d events, want %d", len(got), users*perUser)
+	}
+	next := make(map[string]int)
+	for _, e := range got {
+		if e.seq != next[e.user] {
+			t.Fatalf("%s: got event %d, want %d", e.user, e.seq, next[e.user])
+		}
+		next[e.user]++
+	}
+}
+
+func jittered(e event) event {
+	time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+	return e
+}
+
+func byUser(e event) string { return e.user }
+
+func TestPartitionKeepsPerKeyOrder(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	p := Partition(done, events(done, 20, 50), 4, 2, byUser, jittered)
+	checkPerKeyOrder(t, collect(p.Out()), 20, 50)
+}
+
+func TestPartitionResizeLosesNothing(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	p := Partition(done, events(done, 20, 100), 2, 4, byUser, jittered)
+	var got []event
+	for e := range p.Out() {
+		got = append(got, e)
+		switch len(got) { //Here we resize from the consuming goroutine itself, which must not deadlock.
+		case 300:
+			p.Resize(6)
+		case 900:
+			p.Resize(3)
+		case 1500:
+			p.Resize(1)
+		}
+	}
+	checkPerKeyOrder(t, got, 20, 100)
+	if n := p.Workers(); n != 1 {
+		t.Fatalf("Workers() = %d, want 1", n)
+	}
+}
+
+func TestRingMovesFewKeys(t *testing.T) {
+	before, after := newRing(4), newRing(5)
+	load := make([]int, 5)
+	moved := 0
+	const keys = 10000
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		owner := after.owner(key)
+		load[owner]++
+		if owner != before.owner(key) {
+			moved++
+			if owner != 4 {
+				t.Fatalf("%s moved between two old workers", key)
+			}
+		}
+	}
+	if moved > keys*2/5 {
+		t.Fatalf("%d of %d keys moved going from 4 to 5 workers, want about a fifth", moved, keys)
+	}
+	for w, n := range load {
+		if n < keys/10 || n > keys*3/10 {
+			t.Fatalf("worker %d owns %d of %d keys; the ring is badly unbalanced: %v", w, n, keys, load)
+		}
+	}
+}
+
+func TestPartitionStopsOnDone(t *testing.T) {
+	done := make(chan interface{})
+	p := Partition(done, Repeat(done, event{"a", 0}, event{"b", 0}), 3, 1, byUser, func(e event) event { return e })
+	<-p.Out()
+	p.Resize(5)
+	close(done)
+
+	select {
+	case <-drained(p.Out()):
+	case <-time.After(time.Second):
+		t.Fatal("Partition did not close its output after done was closed")
+	}
+}
@@ -0,0 +1,237 @@
+// Package stages holds the pipeline stages from example-3.go written once with type parameters, so a
+// pipeline of strings no longer pays for boxing every value into an interface{} and asserting it back out.
+//
+// Every stage follows the book's rules: it owns the channel it returns and closes it when it is done, and
+// it stops and closes early as soon as done is closed.
+package stages
+
+import "sync"
+
+// Repeat sends values, over and over, until done is closed. With no values there is nothing to repeat, so
+// the stream is closed straight away.
+func Repeat[T any](done <-chan interface{}, values ...T) <-chan T {
+	valueStream := make(chan T)
+	go func() {
+		defer close(valueStream)
+		if len(values) == 0 {
+			return //Otherwise the loop below would spin without ever looking at done.
+		}
+		for {
+			for _, v := range values {
+				select {
+				case <-done:
+					return
+				case valueStream <- v:
+				}
+			}
+		}
+	}()
+	return valueStream
+}
+
+// RepeatFn sends the result of calling fn, over and over, until done is closed.
+func RepeatFn[T any](done <-chan interface{}, fn func() T) <-chan T {
+	valueStream := make(chan T)
+	go func() {
+		defer close(valueStream)
+		for {
+			select {
+			case <-done:
+				return
+			case valueStream <- fn():
+			}
+		}
+	}()
+	return valueStream
+}
+
+// Take passes on the first num values from valueStream, then closes.
+func Take[T any](done <-chan interface{}, valueStream <-chan T, num int) <-chan T {
+	takeStream := make(chan T)
+	go func() {
+		defer close(takeStream)
+		for i := 0; i < num; i++ {
+			var v T
+			select { //Unlike the book's take, we don't block on <-valueStream outside the select, so a closed done always gets us out.
+			case <-done:
+				return
+			case value, ok := <-valueStream:
+				if !ok {
+					return
+				}
+				v = value
+			}
+			select {
+			case <-done:
+				return
+			case takeStream <- v:
+			}
+		}
+	}()
+	return takeStream
+}
+
+// OrDone lets a caller range over c without also having to select on done.
+func OrDone[T any](done <-chan interface{}, c <-chan T) <-chan T {
+	valStream := make(chan T)
+	go func() {
+		defer close(valStream)
+		for {
+			select {
+			case <-done:
+				return
+			case v, ok := <-c:
+				if !ok {
+					return
+				}
+				select {
+				case valStream <- v:
+				case <-done:
+				}
+			}
+		}
+	}()
+	return valStream
+}
+
+// Tee sends every value from in to both returned channels. As with the book's tee, a value isn't read
+// from in until both outputs have taken the previous one.
+func Tee[T any](done <-chan interface{}, in <-chan T) (<-chan T, <-chan T) {
+	out1 := make(chan T)
+	out2 := make(chan T)
+	go func() {
+		defer close(out1)
+		defer close(out2)
+		for val := range OrDone(done, in) {
+			var out1, out2 = out1, out2
+			for i := 0; i < 2; i++ {
+				select {
+				case <-done:
+				case out1 <- val:
+					out1 = nil
+				case out2 <- val:
+					out2 = nil
+				}
+			}
+		}
+	}()
+	return out1, out2
+}
+
+// Bridge flattens a channel of channels into a single channel, reading each inner channel to the end
+// before moving on to the next.
+func Bridge[T any](done <-chan interface{}, chanStream <-chan <-chan T) <-chan T {
+	valStream := make(chan T)
+	go func() {
+		defer close(valStream)
+		for {
+			var stream <-chan T
+			select {
+			case maybeStream, ok := <-chanStream:
+				if !ok {
+					return
+				}
+				stream = maybeStream
+			case <-done:
+				return
+			}
+			for val := range OrDone(done, stream) {
+				select {
+				case valStream <- val:
+				case <-done:
+				}
+			}
+		}
+	}()
+	return valStream
+}
+
+// FanIn multiplexes channels onto one channel. Values come out in whatever order they arrive.
+func FanIn[T any](done <-chan interface{}, channels ...<-chan T) <-chan T {
+	var wg sync.WaitGroup
+	multiplexedStream := make(chan T)
+
+	multiplex := func(c <-chan T) {
+		defer wg.Done()
+		for i := range c {
+			select {
+			case <-done:
+				return
+			case multiplexedStream <- i:
+			}
+		}
+	}
+
+	wg.Add(len(channels))
+	for _, c := range channels {
+		go multiplex(c)
+	}
+
+	go func() {
+		wg.Wait()
+		close(multiplexedStream)
+	}()
+
+	return multiplexedStream
+}
+
+// FanOut starts n copies of Map(done, in, fn) all reading from in, and returns their outputs. Feed them to
+// FanIn to get a single stream back.
+func FanOut[T, R any](done <-chan interface{}, in <-chan T, n int, fn func(T) R) []<-chan R {
+	outs := make([]<-chan R, n)
+	for i := range outs {
+		outs[i] = Map(done, in, fn)
+	}
+	return outs
+}
+
+// Map sends fn(v) for every v from in.
+func Map[T, R any](done <-chan interface{}, in <-chan T, fn func(T) R) <-chan R {
+	out := make(chan R)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-done:
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case <-done:
+					return
+				case out <- fn(v):
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Filter passes on only the values from in for which keep returns true.
+func Filter[T any](done <-chan interface{}, in <-chan T, keep func(T) bool) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-done:
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				if !keep(v) {
+					continue
+				}
+				select {
+				case <-done:
+					return
+				case out <- v:
+				}
+			}
+		}
+	}()
+	return out
+}
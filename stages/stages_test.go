@@ -0,0 +1,181 @@
+package stages
+
+import (
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func collect[T any](c <-chan T) []T {
+	var out []T
+	for v := range c {
+		out = append(out, v)
+	}
+	return out
+}
+
+func from[T any](values ...T) <-chan T {
+	c := make(chan T, len(values))
+	for _, v := range values {
+		c <- v
+	}
+	close(c)
+	return c
+}
+
+func TestRepeatTake(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	got := collect(Take(done, Repeat(done, 1, 2), 5))
+	if want := []int{1, 2, 1, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestRepeatNothing(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	select {
+	case <-drained(Repeat[int](done)):
+	case <-time.After(time.Second):
+		t.Fatal("Repeat with no values did not close its output")
+	}
+}
+
+func TestTakeStopsWhenInputCloses(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	got := collect(Take(done, from("a", "b"), 10))
+	if want := []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestRepeatFn(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	n := 0
+	got := collect(Take(done, RepeatFn(done, func() int { n++; return n }), 3))
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestMapFilter(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	even := Filter(done, from(1, 2, 3, 4, 5, 6), func(v int) bool { return v%2 == 0 })
+	got := collect(Map(done, even, strconv.Itoa))
+	if want := []string{"2", "4", "6"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestTee(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	out1, out2 := Tee(done, from(1, 2, 3))
+	var got1, got2 []int
+	for v := range out1 {
+		got1 = append(got1, v)
+		got2 = append(got2, <-out2)
+	}
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(got1, want) || !reflect.DeepEqual(got2, want) {
+		t.Fatalf("got %v and %v, want %v on both", got1, got2, want)
+	}
+}
+
+func TestBridge(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	chanStream := make(chan (<-chan int))
+	go func() {
+		defer close(chanStream)
+		for i := 0; i < 5; i++ {
+			chanStream <- from(i, i*10)
+		}
+	}()
+	got := collect(Bridge(done, chanStream))
+	if want := []int{0, 0, 1, 10, 2, 20, 3, 30, 4, 40}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestFanOutFanIn(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	in := make(chan int)
+	go func() {
+		defer close(in)
+		for i := 0; i < 100; i++ {
+			in <- i
+		}
+	}()
+	got := collect(FanIn(done, FanOut(done, in, 4, func(v int) int { return v * v })...))
+	sort.Ints(got)
+	if len(got) != 100 {
+		t.Fatalf("got %d values, want 100", len(got))
+	}
+	for i, v := range got {
+		if v != i*i {
+			t.Fatalf("got[%d] = %d, want %d", i, v, i*i)
+		}
+	}
+}
+
+func TestStagesExitWhenDoneCloses(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	done := make(chan interface{})
+	never := make(chan int) //Nothing is ever sent on this, so only done can get the stages reading it to stop.
+	chanStream := make(chan (<-chan int), 1)
+	chanStream <- never
+	out1, _ := Tee(done, Map(done, Filter(done, never, func(int) bool { return true }), func(v int) int { return v }))
+	stages := []<-chan int{
+		Take(done, Repeat(done, 1), 1000),
+		RepeatFn(done, func() int { return 1 }),
+		OrDone(done, never),
+		Bridge(done, chanStream),
+		out1,
+	}
+	stages = append(stages, FanOut(done, never, 3, func(v int) int { return v })...)
+	<-stages[0]
+	close(done)
+
+	for _, c := range stages {
+		select {
+		case <-drained(c):
+		case <-time.After(time.Second):
+			t.Fatal("a stage did not close its output after done was closed")
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("%d goroutines still running, want %d", n, before)
+	}
+}
+
+func drained[T any](c <-chan T) <-chan struct{} {
+	closed := make(chan struct{})
+	go func() {
+		for range c {
+		}
+		close(closed)
+	}()
+	return closed
+}
@@ -0,0 +1,159 @@
+package stages
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrOutputFull is what a FailWhenFull output reports once its reader fell more than its buffer behind.
+var ErrOutputFull = errors.New("stages: tee output fell too far behind")
+
+// OverflowPolicy says what TeeN does with a value for an output whose reader hasn't made room for it.
+type OverflowPolicy int
+
+const (
+	Block        OverflowPolicy = iota // Wait for the reader, holding up every other output, as the book's tee does.
+	DropNewest                         // Throw away the new value.
+	DropOldest                         // Throw away the oldest buffered value to make room, so the reader always sees the latest.
+	FailWhenFull                       // Stop sending to this output: close it and report ErrOutputFull from Err.
+)
+
+// Branch configures one output of TeeN.
+type Branch struct {
+	Policy OverflowPolicy
+	Buffer int
+}
+
+// Output is one of the channels TeeN sends to, along with how it has fared.
+type Output[T any] struct {
+	C <-chan T
+
+	c       chan T
+	branch  Branch
+	dropped int64
+
+	mu     sync.Mutex
+	err    error
+	closed bool
+}
+
+// Dropped is how many values this output has not been sent, whether thrown away or, after a FailWhenFull
+// output gave up, never offered.
+func (o *Output[T]) Dropped() int64 {
+	return atomic.LoadInt64(&o.dropped)
+}
+
+// Err is ErrOutputFull if this output gave up on a reader that fell too far behind, and nil otherwise.
+func (o *Output[T]) Err() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err
+}
+
+func (o *Output[T]) close(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return
+	}
+	o.err, o.closed = err, true
+	close(o.c)
+}
+
+// offer hands v to a non-blocking output without ever waiting for its reader.
+func (o *Output[T]) offer(v T) {
+	if o.Err() != nil {
+		atomic.AddInt64(&o.dropped, 1)
+		return
+	}
+	switch o.branch.Policy {
+	case DropNewest:
+		select {
+		case o.c <- v:
+		default:
+			atomic.AddInt64(&o.dropped, 1)
+		}
+	case DropOldest:
+		for {
+			select {
+			case o.c <- v:
+				return
+			default:
+			}
+			select {
+			case <-o.c: //Here we take the oldest value back out of the reader's buffer. We are the only sender, so it was ours to take.
+				atomic.AddInt64(&o.dropped, 1)
+			default: //The reader got there first, so there is room now.
+			}
+		}
+	case FailWhenFull:
+		select {
+		case o.c <- v:
+		default:
+			atomic.AddInt64(&o.dropped, 1)
+			o.close(ErrOutputFull)
+		}
+	}
+}
+
+// TeeN sends every value from in to one output per branch. Unlike Tee, each output decides for itself
+// what happens when its reader is slow, so a slow auditor can no longer hold up the executor: only Block
+// outputs are waited for, and values are buffered, dropped or refused for the others.
+func TeeN[T any](done <-chan interface{}, in <-chan T, branches ...Branch) []*Output[T] {
+	outputs := make([]*Output[T], len(branches))
+	var blocking []*Output[T]
+	for i, branch := range branches {
+		if branch.Policy == DropOldest && branch.Buffer < 1 {
+			branch.Buffer = 1 //With nowhere to keep an oldest value, there would be nothing to drop to make room.
+		}
+		c := make(chan T, branch.Buffer)
+		outputs[i] = &Output[T]{C: c, c: c, branch: branch}
+		if branch.Policy == Block {
+			blocking = append(blocking, outputs[i])
+		}
+	}
+
+	go func() {
+		defer func() {
+			for _, o := range outputs {
+				o.close(nil)
+			}
+		}()
+		for val := range OrDone(done, in) {
+			for _, o := range outputs {
+				if o.branch.Policy != Block {
+					o.offer(val)
+				}
+			}
+			if !sendAll(done, blocking, val) {
+				return
+			}
+		}
+	}()
+	return outputs
+}
+
+// sendAll waits until every output in blocking has taken val. Like the book's tee, it selects over all of
+// them at once and drops each one from the select once it has been written to, so readers can take their
+// values in any order; with a variable number of outputs that select has to be built with reflect.
+func sendAll[T any](done <-chan interface{}, blocking []*Output[T], val T) bool {
+	if len(blocking) == 0 {
+		return true
+	}
+	cases := make([]reflect.SelectCase, len(blocking)+1)
+	cases[0] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(done)}
+	v := reflect.ValueOf(&val).Elem()
+	for i, o := range blocking {
+		cases[i+1] = reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(o.c), Send: v}
+	}
+	for range blocking {
+		chosen, _, _ := reflect.Select(cases)
+		if chosen == 0 {
+			return false
+		}
+		cases[chosen].Chan = reflect.Value{} //A zero Chan is never ready, just like a nil channel in a select statement.
+	}
+	return true
+}
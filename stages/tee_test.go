@@ -0,0 +1,82 @@
+package stages
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTeeNPolicies(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	outputs := TeeN(done, from(1, 2, 3, 4, 5),
+		Branch{Policy: Block},
+		Branch{Policy: DropNewest, Buffer: 2},
+		Branch{Policy: DropOldest, Buffer: 2},
+		Branch{Policy: FailWhenFull, Buffer: 2},
+	)
+	executor, newest, oldest, failing := outputs[0], outputs[1], outputs[2], outputs[3]
+
+	if got := collect(executor.C); !reflect.DeepEqual(got, []int{1, 2, 3, 4, 5}) { //Nobody is reading the other three, and the executor still gets everything.
+		t.Fatalf("executor got %v", got)
+	}
+
+	tests := []struct {
+		name    string
+		output  *Output[int]
+		want    []int
+		dropped int64
+		err     error
+	}{
+		{"drop newest", newest, []int{1, 2}, 3, nil},
+		{"drop oldest", oldest, []int{4, 5}, 3, nil},
+		{"fail when full", failing, []int{1, 2}, 3, ErrOutputFull},
+	}
+	for _, tt := range tests {
+		if got := collect(tt.output.C); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+		if n := tt.output.Dropped(); n != tt.dropped {
+			t.Errorf("%s: Dropped() = %d, want %d", tt.name, n, tt.dropped)
+		}
+		if err := tt.output.Err(); !errors.Is(err, tt.err) {
+			t.Errorf("%s: Err() = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestTeeNBlockingOutputsInAnyOrder(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	outputs := TeeN(done, from(1, 2, 3), Branch{Policy: Block}, Branch{Policy: Block}, Branch{Policy: Block})
+	for want := 1; want <= 3; want++ {
+		for i := len(outputs) - 1; i >= 0; i-- { //Here we read the last output first, which would deadlock if TeeN wrote to them one after another.
+			select {
+			case got := <-outputs[i].C:
+				if got != want {
+					t.Fatalf("output %d got %d, want %d", i, got, want)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("output %d never got %d", i, want)
+			}
+		}
+	}
+}
+
+func TestTeeNStopsOnDone(t *testing.T) {
+	done := make(chan interface{})
+	outputs := TeeN(done, Repeat(done, 1), Branch{Policy: Block}, Branch{Policy: DropOldest, Buffer: 4})
+	<-outputs[0].C
+	close(done)
+
+	for _, o := range outputs {
+		select {
+		case <-drained(o.C):
+		case <-time.After(time.Second):
+			t.Fatal("TeeN did not close its outputs after done was closed")
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package stages
+
+import (
+	"container/heap"
+	"time"
+)
+
+// Window is what a windowing stage sends once a window has closed: every item with Key whose event time
+// fell in [Start, End), folded together by the Reduce function.
+type Window[K comparable, A any] struct {
+	Key        K
+	Start, End time.Time
+	Count      int
+	Value      A
+}
+
+// WindowConfig says how items are grouped and folded.
+//
+// Time is event time, the time an item says it happened, not when it reached us. Since items can arrive out
+// of order, a window isn't closed as soon as an item past its end shows up; we keep a watermark, the latest
+// event time seen so far minus Lateness, and a window closes once the watermark passes its end. An item that
+// arrives after every window it belongs to has closed is late: it is handed to OnLate, or dropped if OnLate
+// is nil, and never changes a window that has already been sent.
+type WindowConfig[T any, K comparable, A any] struct {
+	Key       func(T) K
+	EventTime func(T) time.Time // Defaults to the time the item arrives, in which case nothing is ever late and windows close on the clock, even while no items arrive.
+	Reduce    func(acc A, v T) A
+	Lateness  time.Duration
+	OnLate    func(T)
+}
+
+// Tumbling sends one Window per key for each back-to-back, non-overlapping period of size. It panics if size
+// isn't positive.
+func Tumbling[T any, K comparable, A any](done <-chan interface{}, in <-chan T, size time.Duration, config WindowConfig[T, K, A]) <-chan Window[K, A] {
+	if size <= 0 {
+		panic("stages: Tumbling needs a positive size")
+	}
+	return Sliding(done, in, size, size, config)
+}
+
+// Sliding sends one Window per key for every period of size starting at each multiple of slide, as
+// time.Truncate rounds them, so an item counts towards size/slide overlapping windows. With slide larger
+// than size the windows hop, and an item that falls between two of them belongs to none: it is dropped,
+// but it isn't late. It panics if size or slide isn't positive.
+func Sliding[T any, K comparable, A any](done <-chan interface{}, in <-chan T, size, slide time.Duration, config WindowConfig[T, K, A]) <-chan Window[K, A] {
+	if size <= 0 || slide <= 0 {
+		panic("stages: Sliding needs a positive size and slide")
+	}
+	w := newWindower(config)
+	w.add = w.addSliding(size, slide)
+	return w.run(done, in)
+}
+
+// Session sends one Window per key for each burst of activity: a session lasts until no item for its key
+// has happened for gap. An out-of-order item can join two sessions into one, so, unlike the other windows,
+// a session keeps its items and reduces them only when it closes. It panics if gap isn't positive.
+func Session[T any, K comparable, A any](done <-chan interface{}, in <-chan T, gap time.Duration, config WindowConfig[T, K, A]) <-chan Window[K, A] {
+	if gap <= 0 {
+		panic("stages: Session needs a positive gap")
+	}
+	w := newWindower(config)
+	w.add = w.addSession(gap)
+	return w.run(done, in)
+}
+
+type pane[T any, K comparable, A any] struct {
+	Window[K, A]
+	seq   int
+	items []T
+	index int // Where the pane is in the windower's heap.
+}
+
+// paneHeap orders the open panes by when they close, and panes that close together by when they were
+// opened, so the windower only ever looks at the panes the watermark has just passed.
+type paneHeap[T any, K comparable, A any] []*pane[T, K, A]
+
+func (h paneHeap[T, K, A]) Len() int { return len(h) }
+
+func (h paneHeap[T, K, A]) Less(i, j int) bool {
+	if !h[i].End.Equal(h[j].End) {
+		return h[i].End.Before(h[j].End)
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h paneHeap[T, K, A]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *paneHeap[T, K, A]) Push(x interface{}) {
+	p := x.(*pane[T, K, A])
+	p.index = len(*h)
+	*h = append(*h, p)
+}
+
+func (h *paneHeap[T, K, A]) Pop() interface{} {
+	old := *h
+	p := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return p
+}
+
+type paneID[K comparable] struct {
+	key   K
+	start int64
+}
+
+type windower[T any, K comparable, A any] struct {
+	WindowConfig[T, K, A]
+	add       func(v T, key K, at time.Time) bool
+	clock     bool // EventTime is the arrival time, so the watermark can follow the clock between items.
+	watermark time.Time
+	seq       int
+	open      paneHeap[T, K, A]
+	slides    map[paneID[K]]*pane[T, K, A]
+	sessions  map[K][]*pane[T, K, A]
+}
+
+func newWindower[T any, K comparable, A any](config WindowConfig[T, K, A]) *windower[T, K, A] {
+	clock := config.EventTime == nil
+	if clock {
+		config.EventTime = func(T) time.Time { return time.Now() }
+	}
+	return &windower[T, K, A]{
+		WindowConfig: config,
+		clock:        clock,
+		slides:       make(map[paneID[K]]*pane[T, K, A]),
+		sessions:     make(map[K][]*pane[T, K, A]),
+	}
+}
+
+func (w *windower[T, K, A]) run(done <-chan interface{}, in <-chan T) <-chan Window[K, A] {
+	out := make(chan Window[K, A])
+	go func() {
+		defer close(out)
+
+		var timer *time.Timer //Here we wake up when the next window is due, so a quiet key's window still closes on time.
+		var due time.Time
+		var wake <-chan time.Time
+		if w.clock {
+			timer = time.NewTimer(time.Hour)
+			defer timer.Stop()
+			timer.Stop()
+			wake = timer.C
+		}
+
+		for {
+			if w.clock && len(w.open) > 0 && !w.open[0].End.Add(w.Lateness).Equal(due) {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				due = w.open[0].End.Add(w.Lateness)
+				timer.Reset(time.Until(due))
+			}
+
+			select {
+			case <-done:
+				return
+			case <-wake:
+				due = time.Time{}
+				if mark := time.Now().Add(-w.Lateness); mark.After(w.watermark) {
+					w.watermark = mark
+				}
+				if !w.emit(done, out, false) {
+					return
+				}
+			case v, ok := <-in:
+				if !ok {
+					w.emit(done, out, true) //The stream is over, so there is nothing left to wait for.
+					return
+				}
+				at := w.EventTime(v)
+				if !w.add(v, w.Key(v), at) {
+					if w.OnLate != nil {
+						w.OnLate(v)
+					}
+					continue
+				}
+				if mark := at.Add(-w.Lateness); mark.After(w.watermark) {
+					w.watermark = mark
+				}
+				if !w.emit(done, out, false) {
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// emit sends every window the watermark has passed, or every window at all, in the order they ended, and
+// windows that ended together in the order they were opened.
+func (w *windower[T, K, A]) emit(done <-chan interface{}, out chan<- Window[K, A], all bool) bool {
+	for len(w.open) > 0 && (all || !w.open[0].End.After(w.watermark)) {
+		p := heap.Pop(&w.open).(*pane[T, K, A])
+		w.forget(p)
+		for _, v := range p.items {
+			p.Value = w.Reduce(p.Value, v)
+		}
+		select {
+		case <-done:
+			return false
+		case out <- p.Window:
+		}
+	}
+	return true
+}
+
+// forget drops a closed pane from whichever map finds it for new items.
+func (w *windower[T, K, A]) forget(p *pane[T, K, A]) {
+	if id := (paneID[K]{p.Key, p.Start.UnixNano()}); w.slides[id] == p {
+		delete(w.slides, id)
+		return
+	}
+	panes := w.sessions[p.Key]
+	for i, q := range panes {
+		if q == p {
+			panes = append(panes[:i], panes[i+1:]...)
+			break
+		}
+	}
+	if len(panes) == 0 {
+		delete(w.sessions, p.Key)
+	} else {
+		w.sessions[p.Key] = panes
+	}
+}
+
+func (w *windower[T, K, A]) newPane(key K, start, end time.Time) *pane[T, K, A] {
+	w.seq++
+	return &pane[T, K, A]{Window: Window[K, A]{Key: key, Start: start, End: end}, seq: w.seq}
+}
+
+func (w *windower[T, K, A]) addSliding(size, slide time.Duration) func(v T, key K, at time.Time) bool {
+	return func(v T, key K, at time.Time) bool {
+		accepted, windows := false, 0
+		for start := at.Truncate(slide); start.Add(size).After(at); start = start.Add(-slide) {
+			windows++
+			end := start.Add(size)
+			if !end.After(w.watermark) {
+				break //This window and every earlier one has already been sent.
+			}
+			id := paneID[K]{key, start.UnixNano()}
+			p, ok := w.slides[id]
+			if !ok {
+				p = w.newPane(key, start, end)
+				w.slides[id] = p
+				heap.Push(&w.open, p)
+			}
+			p.Value = w.Reduce(p.Value, v)
+			p.Count++
+			accepted = true
+		}
+		return accepted || windows == 0 //An item between two hopping windows belongs to neither; that doesn't make it late.
+	}
+}
+
+func (w *windower[T, K, A]) addSession(gap time.Duration) func(v T, key K, at time.Time) bool {
+	return func(v T, key K, at time.Time) bool {
+		joined := w.newPane(key, at, at.Add(gap))
+		if !joined.End.After(w.watermark) {
+			return false
+		}
+		joined.items = []T{v}
+		joined.Count = 1
+
+		var rest []*pane[T, K, A]
+		for _, p := range w.sessions[key] {
+			if p.Start.Before(joined.End) && joined.Start.Before(p.End) { //Here the item falls within gap of this session, so the two become one.
+				if p.Start.Before(joined.Start) {
+					joined.Start = p.Start
+				}
+				if p.End.After(joined.End) {
+					joined.End = p.End
+				}
+				if p.seq < joined.seq {
+					joined.seq = p.seq
+				}
+				joined.items = append(p.items, joined.items...)
+				joined.Count += p.Count
+				heap.Remove(&w.open, p.index)
+			} else {
+				rest = append(rest, p)
+			}
+		}
+		w.sessions[key] = append(rest, joined)
+		heap.Push(&w.open, joined)
+		return true
+	}
+}
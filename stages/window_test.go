@@ -0,0 +1,204 @@
+package stages
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type reading struct {
+	host  string
+	at    int // Seconds since base.
+	value int
+}
+
+var base = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func sumConfig(lateness time.Duration, late *[]reading) WindowConfig[reading, string, int] {
+	return WindowConfig[reading, string, int]{
+		Key:       func(r reading) string { return r.host },
+		EventTime: func(r reading) time.Time { return base.Add(time.Duration(r.at) * time.Second) },
+		Reduce:    func(sum int, r reading) int { return sum + r.value },
+		Lateness:  lateness,
+		OnLate:    func(r reading) { *late = append(*late, r) },
+	}
+}
+
+func describe(windows []Window[string, int]) []string {
+	var out []string
+	for _, w := range windows {
+		out = append(out, fmt.Sprintf("%s [%v,%v) n=%d sum=%d", w.Key, w.Start.Sub(base).Seconds(), w.End.Sub(base).Seconds(), w.Count, w.Value))
+	}
+	return out
+}
+
+func TestTumbling(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	var late []reading
+	in := from(
+		reading{"a", 1, 1}, reading{"b", 2, 10}, reading{"a", 9, 2},
+		reading{"a", 11, 4},  //The watermark reaches 11, closing [0,10) for both hosts.
+		reading{"a", 3, 100}, //Its window has been sent, so it is late.
+		reading{"b", 19, 20},
+	)
+	got := describe(collect(Tumbling(done, in, 10*time.Second, sumConfig(0, &late))))
+	want := []string{
+		"a [0,10) n=2 sum=3",
+		"b [0,10) n=1 sum=10",
+		"a [10,20) n=1 sum=4", //The rest is flushed when in closes.
+		"b [10,20) n=1 sum=20",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got\n%v\nwant\n%v", got, want)
+	}
+	if want := []reading{{"a", 3, 100}}; !reflect.DeepEqual(late, want) {
+		t.Fatalf("late = %v, want %v", late, want)
+	}
+}
+
+func TestTumblingAllowsLateness(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	var late []reading
+	in := from(reading{"a", 1, 1}, reading{"a", 12, 2}, reading{"a", 8, 4}, reading{"a", 16, 8}, reading{"a", 7, 16})
+	got := describe(collect(Tumbling(done, in, 10*time.Second, sumConfig(5*time.Second, &late))))
+	want := []string{
+		"a [0,10) n=2 sum=5", //The item at 8 arrived after the one at 12, but within the 5s we allowed.
+		"a [10,20) n=2 sum=10",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got\n%v\nwant\n%v", got, want)
+	}
+	if want := []reading{{"a", 7, 16}}; !reflect.DeepEqual(late, want) { //By then the watermark was 16-5 = 11, past the end of [0,10).
+		t.Fatalf("late = %v, want %v", late, want)
+	}
+}
+
+func TestSliding(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	var late []reading
+	in := from(reading{"a", 1, 1}, reading{"a", 6, 2}, reading{"a", 12, 4})
+	got := describe(collect(Sliding(done, in, 10*time.Second, 5*time.Second, sumConfig(0, &late))))
+	want := []string{
+		"a [-5,5) n=1 sum=1",
+		"a [0,10) n=2 sum=3",
+		"a [5,15) n=2 sum=6",
+		"a [10,20) n=1 sum=4",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestHoppingSkipsGaps(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	var late []reading
+	in := from(reading{"a", 1, 1}, reading{"a", 30, 2}, reading{"a", 65, 4}) //The item at 30 falls between [0,10) and [60,70).
+	got := describe(collect(Sliding(done, in, 10*time.Second, time.Minute, sumConfig(0, &late))))
+	want := []string{
+		"a [0,10) n=1 sum=1",
+		"a [60,70) n=1 sum=4",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got\n%v\nwant\n%v", got, want)
+	}
+	if len(late) != 0 {
+		t.Fatalf("late = %v, want nothing: an item between windows isn't late", late)
+	}
+}
+
+func TestProcessingTimeClosesIdleWindows(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	in := make(chan string)
+	defer close(in)
+	out := Tumbling(done, in, 20*time.Millisecond, WindowConfig[string, string, int]{
+		Key:    func(host string) string { return host },
+		Reduce: func(n int, host string) int { return n + 1 },
+	})
+	in <- "a"
+	in <- "a"
+
+	select { //Nothing else arrives, but the clock alone should close the window.
+	case w := <-out:
+		if w.Key != "a" || w.Count != 2 || w.Value != 2 {
+			t.Fatalf("got %+v, want both items for a", w)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the window was not sent while the input was idle")
+	}
+}
+
+func TestSession(t *testing.T) {
+	done := make(chan interface{})
+	defer close(done)
+
+	var late []reading
+	in := from(
+		reading{"a", 0, 1}, reading{"a", 3, 2}, reading{"b", 4, 10},
+		reading{"a", 20, 4},  //With 15s of lateness the watermark is only at 5, so nothing has closed yet.
+		reading{"a", 10, 8},  //Between the two sessions for a, but more than 5s from both.
+		reading{"a", 6, 16},  //Within 5s of both the first session and the item at 10, so they become one.
+		reading{"a", 40, 32}, //The watermark moves to 25 and closes everything before it.
+		reading{"a", 2, 64},  //Long past the end of a's first session.
+	)
+	got := describe(collect(Session(done, in, 5*time.Second, sumConfig(15*time.Second, &late))))
+	want := []string{
+		"b [4,9) n=1 sum=10",
+		"a [0,15) n=4 sum=27",
+		"a [20,25) n=1 sum=4",
+		"a [40,45) n=1 sum=32",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got\n%v\nwant\n%v", got, want)
+	}
+	if want := []reading{{"a", 2, 64}}; !reflect.DeepEqual(late, want) {
+		t.Fatalf("late = %v, want %v", late, want)
+	}
+}
+
+func TestWindowStopsOnDone(t *testing.T) {
+	done := make(chan interface{})
+	var late []reading
+	n := 0
+	in := RepeatFn(done, func() reading { n++; return reading{"a", n, 1} })
+	out := Tumbling(done, in, time.Second, sumConfig(0, &late))
+	<-out
+	close(done)
+
+	select {
+	case <-drained(out):
+	case <-time.After(time.Second):
+		t.Fatal("Tumbling did not close its output after done was closed")
+	}
+}
+
+func TestWindowsRejectNonPositiveDurations(t *testing.T) {
+	var late []reading
+	config := sumConfig(0, &late)
+	for name, start := range map[string]func(){
+		"Tumbling with size 0":  func() { Tumbling(nil, from[reading](), 0, config) },
+		"Sliding with size 0":   func() { Sliding(nil, from[reading](), 0, time.Second, config) },
+		"Sliding with slide 0":  func() { Sliding(nil, from[reading](), time.Second, 0, config) },
+		"Sliding with slide -1": func() { Sliding(nil, from[reading](), time.Second, -time.Second, config) },
+		"Session with gap 0":    func() { Session(nil, from[reading](), 0, config) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s didn't panic", name)
+				}
+			}()
+			start()
+		}()
+	}
+}